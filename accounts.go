@@ -0,0 +1,431 @@
+// ---------------------------------------------------------------------------
+// Player accounts.
+//
+// A player may register a name with a password, and then log in from any
+// device. Accounts are kept in ACCOUNTS_FILE, with each password salted and
+// hashed. Logging in gives the browser a session cookie (secure, HTTP only,
+// and sent only to this site), and a game played over a socket opened with
+// it is played under the account's name, whatever name the client sends.
+// Sessions are kept in SESSIONS_FILE, by a hash of each cookie's token, so a
+// restart logs nobody out.
+//
+// Anyone may still play as a guest, under a name of their own choosing, but
+// not under the name of an account or a bot. Only a game played under an
+// account is the account's.
+//
+// Each password guess costs the server a slow hash, so logins and
+// registrations from each address are limited to MAX_ATTEMPTS every
+// ATTEMPT_WINDOW, and to MAX_NAME_ATTEMPTS for any one name. Further
+// attempts get 429 before any hashing is done. Nobody elsewhere can lock a
+// player out of their own account.
+//
+//    POST /account/register   {Name, Password}  Creates an account, logs in
+//    POST /account/login      {Name, Password}
+//    POST /account/logout
+//    GET  /account/me         {Name}, or 401 if not logged in
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ACCOUNTS_FILE = "accounts.json"
+const SESSIONS_FILE = "sessions.json"
+const SESSION_COOKIE = "memsession"
+const SESSION_LIFE = 30 * 24 * time.Hour
+
+const HASH_ITER = 600000
+const HASH_LEN = 32
+const MIN_PASSWORD = 8
+
+const MAX_ATTEMPTS = 10            // Logins or registrations per address,
+const MAX_NAME_ATTEMPTS = 5        // ... and per address for one name,
+const ATTEMPT_WINDOW = time.Minute // ... in this long
+
+var accountName = regexp.MustCompile(`^[A-Za-z0-9_-]{3,20}$`)
+
+type account_t struct {
+	Name    string
+	Salt    []byte
+	Hash    []byte
+	Created time.Time
+}
+
+type session_t struct {
+	Name    string
+	Expires time.Time
+}
+
+// Guards the accounts and sessions
+var AccountsMu sync.Mutex
+
+var Accounts = make(map[string]*account_t) // By name in lower case
+var Sessions = make(map[string]session_t)  // By sessionKey of the token
+
+type attempts_t struct {
+	count int
+	since time.Time // Start of the window
+}
+
+// Guards the attempt counts
+var AttemptsMu sync.Mutex
+
+var Attempts = make(map[string]attempts_t) // By address, or address and name
+
+// ---------------------------------------------------------------------------
+// Load the accounts at startup. No file yet is no accounts yet.
+// ---------------------------------------------------------------------------
+
+func loadAccounts() {
+	accountsJson, err := os.ReadFile(ACCOUNTS_FILE)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalln("Cannot read accounts:", err)
+	}
+
+	var accounts []*account_t
+	err = json.Unmarshal(accountsJson, &accounts)
+	if err != nil {
+		log.Fatalln("Cannot read accounts:", err)
+	}
+	for _, acc := range accounts {
+		Accounts[strings.ToLower(acc.Name)] = acc
+	}
+}
+
+// Write every account, replacing the file whole. Called with AccountsMu held.
+func saveAccounts() error {
+	accounts := []*account_t{}
+	for _, acc := range Accounts {
+		accounts = append(accounts, acc)
+	}
+	accountsJson, err := json.MarshalIndent(accounts, "", " ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(ACCOUNTS_FILE+".new", accountsJson, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(ACCOUNTS_FILE+".new", ACCOUNTS_FILE)
+}
+
+// ---------------------------------------------------------------------------
+// Load the sessions at startup, dropping any that have expired. Sessions
+// are saved whenever one starts or ends. A session lost to a failed save
+// lasts until the server restarts.
+// ---------------------------------------------------------------------------
+
+func loadSessions() {
+	sessionsJson, err := os.ReadFile(SESSIONS_FILE)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(sessionsJson, &Sessions)
+	}
+	if err != nil {
+		log.Println("Sessions not read, so everybody must log in again:", err)
+		Sessions = make(map[string]session_t)
+	}
+	now := time.Now()
+	for key, sess := range Sessions {
+		if now.After(sess.Expires) {
+			delete(Sessions, key)
+		}
+	}
+}
+
+// Write every session still live, replacing the file whole. Called with
+// AccountsMu held.
+func saveSessions() {
+	now := time.Now()
+	for key, sess := range Sessions {
+		if now.After(sess.Expires) {
+			delete(Sessions, key)
+		}
+	}
+	sessionsJson, err := json.MarshalIndent(Sessions, "", " ")
+	if err == nil {
+		err = os.WriteFile(SESSIONS_FILE+".new", sessionsJson, 0600)
+	}
+	if err == nil {
+		err = os.Rename(SESSIONS_FILE+".new", SESSIONS_FILE)
+	}
+	if err != nil {
+		log.Println("Sessions not saved:", err)
+	}
+}
+
+// A session is found by a hash of its token, so the file holds no cookie
+func sessionKey(token string) string {
+	key := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(key[:])
+}
+
+func hashPassword(password string, salt []byte) []byte {
+	hash, err := pbkdf2.Key(sha256.New, password, salt, HASH_ITER, HASH_LEN)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return hash
+}
+
+// ---------------------------------------------------------------------------
+// Returns: true if the name is taken by an account or a bot profile
+// ---------------------------------------------------------------------------
+
+func nameTaken(name string) bool {
+	for _, prof := range BotProfiles {
+		if strings.EqualFold(prof.Name, name) {
+			return true
+		}
+	}
+	AccountsMu.Lock()
+	defer AccountsMu.Unlock()
+	return Accounts[strings.ToLower(name)] != nil
+}
+
+// ---------------------------------------------------------------------------
+// The account a request's session cookie is logged in to, or "" if none
+// ---------------------------------------------------------------------------
+
+func sessionAccount(r *http.Request) string {
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return ""
+	}
+
+	AccountsMu.Lock()
+	defer AccountsMu.Unlock()
+	key := sessionKey(cookie.Value)
+	sess, ok := Sessions[key]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(sess.Expires) {
+		delete(Sessions, key)
+		return ""
+	}
+	return sess.Name
+}
+
+func startSession(w http.ResponseWriter, name string) {
+	token := make([]byte, 32)
+	rand.Read(token)
+	tokenHex := hex.EncodeToString(token)
+
+	AccountsMu.Lock()
+	Sessions[sessionKey(tokenHex)] = session_t{name, time.Now().Add(SESSION_LIFE)}
+	saveSessions()
+	AccountsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: tokenHex, Path: "/",
+		MaxAge: int(SESSION_LIFE / time.Second), Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+}
+
+// ---------------------------------------------------------------------------
+// Play under the account the socket was opened with, or as a guest under a
+// name nobody owns.
+//
+// Returns: false if a guest asked for a name that is not theirs
+// ---------------------------------------------------------------------------
+
+func (player *player_t) identify(account string) bool {
+	if account != "" {
+		player.Name = account
+		player.account = account
+		return true
+	}
+	return !nameTaken(player.Name)
+}
+
+// ---------------------------------------------------------------------------
+// HTTP handlers
+// ---------------------------------------------------------------------------
+
+type credentials_t struct {
+	Name     string
+	Password string
+}
+
+func readCredentials(w http.ResponseWriter, r *http.Request) (credentials_t, bool) {
+	var cred credentials_t
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return cred, false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&cred)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return cred, false
+	}
+	return cred, true
+}
+
+// ---------------------------------------------------------------------------
+// Count an attempt to log in or register as name from the request's
+// address. Returns: false, having answered 429, if the address has made too
+// many, or too many as this name.
+// ---------------------------------------------------------------------------
+
+type attemptLimit_t struct {
+	key string
+	max int
+}
+
+func allowAttempt(w http.ResponseWriter, r *http.Request, name string) bool {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	limits := []attemptLimit_t{{ip, MAX_ATTEMPTS}, {ip + " " + strings.ToLower(name), MAX_NAME_ATTEMPTS}}
+
+	if !countAttempts(limits, time.Now()) {
+		log.Println("Too many login attempts from", ip, "as", name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(ATTEMPT_WINDOW/time.Second)))
+		http.Error(w, "Too many attempts: try again in a minute", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// Count an attempt against each limit, whose windows start with their first
+// attempt. Returns: false if any limit is exceeded.
+func countAttempts(limits []attemptLimit_t, now time.Time) bool {
+	AttemptsMu.Lock()
+	defer AttemptsMu.Unlock()
+
+	for key, a := range Attempts {
+		if now.Sub(a.since) >= ATTEMPT_WINDOW {
+			delete(Attempts, key)
+		}
+	}
+	allowed := true
+	for _, limit := range limits {
+		a := Attempts[limit.key]
+		if a.count == 0 {
+			a.since = now
+		}
+		a.count++
+		Attempts[limit.key] = a
+		if a.count > limit.max {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+func httpRegister(w http.ResponseWriter, r *http.Request) {
+	cred, ok := readCredentials(w, r)
+	if !ok || !allowAttempt(w, r, cred.Name) {
+		return
+	}
+	if !accountName.MatchString(cred.Name) {
+		http.Error(w, "Names are 3 to 20 letters, digits, - or _", http.StatusBadRequest)
+		return
+	}
+	if len(cred.Password) < MIN_PASSWORD || len(cred.Password) > 128 {
+		http.Error(w, "Passwords are 8 to 128 characters", http.StatusBadRequest)
+		return
+	}
+	if nameTaken(cred.Name) {
+		http.Error(w, "That name is taken", http.StatusConflict)
+		return
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	acc := &account_t{cred.Name, salt, hashPassword(cred.Password, salt), time.Now()}
+
+	AccountsMu.Lock()
+	key := strings.ToLower(cred.Name)
+	if Accounts[key] != nil {
+		AccountsMu.Unlock()
+		http.Error(w, "That name is taken", http.StatusConflict)
+		return
+	}
+	Accounts[key] = acc
+	err := saveAccounts()
+	if err != nil {
+		delete(Accounts, key)
+	}
+	AccountsMu.Unlock()
+	if err != nil {
+		log.Println("Account not saved:", err)
+		http.Error(w, "Account not saved", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("New account", acc.Name)
+	startSession(w, acc.Name)
+	json.NewEncoder(w).Encode(map[string]string{"Name": acc.Name})
+}
+
+func httpLogin(w http.ResponseWriter, r *http.Request) {
+	cred, ok := readCredentials(w, r)
+	if !ok || !allowAttempt(w, r, cred.Name) {
+		return
+	}
+
+	AccountsMu.Lock()
+	acc := Accounts[strings.ToLower(cred.Name)]
+	AccountsMu.Unlock()
+
+	// Hash even for an unknown name, so as not to say which names exist
+	salt := make([]byte, 16)
+	if acc != nil {
+		salt = acc.Salt
+	}
+	hash := hashPassword(cred.Password, salt)
+	if acc == nil || subtle.ConstantTimeCompare(hash, acc.Hash) != 1 {
+		http.Error(w, "Wrong name or password", http.StatusUnauthorized)
+		return
+	}
+
+	startSession(w, acc.Name)
+	json.NewEncoder(w).Encode(map[string]string{"Name": acc.Name})
+}
+
+func httpLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+		AccountsMu.Lock()
+		delete(Sessions, sessionKey(cookie.Value))
+		saveSessions()
+		AccountsMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: "", Path: "/", MaxAge: -1,
+		Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+}
+
+func httpMe(w http.ResponseWriter, r *http.Request) {
+	name := sessionAccount(r)
+	if name == "" {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"Name": name})
+}
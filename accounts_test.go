@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Attempts from an address, and as a name from it, each in a window that
+// starts with its first attempt
+// ---------------------------------------------------------------------------
+
+func TestCountAttempts(t *testing.T) {
+	type attempt_t struct {
+		ip      string
+		name    string
+		after   time.Duration // Since the test's first attempt
+		allowed bool
+	}
+	repeat := func(n int, a attempt_t) []attempt_t {
+		as := []attempt_t{}
+		for i := 0; i < n; i++ {
+			as = append(as, a)
+		}
+		return as
+	}
+	names := func(n int, ip string, allowed bool) []attempt_t {
+		as := []attempt_t{}
+		for i := 0; i < n; i++ {
+			as = append(as, attempt_t{ip, "name" + strconv.Itoa(i), 0, allowed})
+		}
+		return as
+	}
+	join := func(parts ...[]attempt_t) []attempt_t {
+		as := []attempt_t{}
+		for _, part := range parts {
+			as = append(as, part...)
+		}
+		return as
+	}
+
+	tests := []struct {
+		name     string
+		attempts []attempt_t
+	}{
+		{"one name, up to its limit", join(
+			repeat(MAX_NAME_ATTEMPTS, attempt_t{"a", "eve", 0, true}),
+			[]attempt_t{{"a", "EVE", 0, false}})},
+		{"another address may still log in as the name", join(
+			repeat(MAX_NAME_ATTEMPTS, attempt_t{"a", "eve", 0, true}),
+			[]attempt_t{{"a", "eve", 0, false}, {"b", "eve", 0, true}})},
+		{"one address, many names", join(
+			names(MAX_ATTEMPTS, "a", true),
+			[]attempt_t{{"a", "another", 0, false}})},
+		{"window resets", join(
+			repeat(MAX_NAME_ATTEMPTS, attempt_t{"a", "eve", 0, true}),
+			[]attempt_t{{"a", "eve", ATTEMPT_WINDOW - time.Second, false},
+				{"a", "eve", ATTEMPT_WINDOW, true}})},
+		{"window starts with the first attempt", join(
+			[]attempt_t{{"a", "eve", 0, true}},
+			repeat(MAX_NAME_ATTEMPTS-1, attempt_t{"a", "eve", ATTEMPT_WINDOW / 2, true}),
+			[]attempt_t{{"a", "eve", ATTEMPT_WINDOW, true}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Attempts = make(map[string]attempts_t)
+			start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+			for n, a := range tt.attempts {
+				limits := []attemptLimit_t{{a.ip, MAX_ATTEMPTS}, {a.ip + " " + strings.ToLower(a.name), MAX_NAME_ATTEMPTS}}
+				if allowed := countAttempts(limits, start.Add(a.after)); allowed != a.allowed {
+					t.Fatalf("attempt %d from %s as %s: allowed %v, want %v", n+1, a.ip, a.name, allowed, a.allowed)
+				}
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Logins over the limit are refused before the password is hashed
+// ---------------------------------------------------------------------------
+
+func TestLoginTooManyAttempts(t *testing.T) {
+	VerboseGlobal = false
+	Attempts = make(map[string]attempts_t)
+	for i := 0; i < MAX_ATTEMPTS; i++ {
+		countAttempts([]attemptLimit_t{{"192.0.2.1", MAX_ATTEMPTS}}, time.Now())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/account/login", bytes.NewBufferString(`{"Name":"eve","Password":"guessing"}`))
+	r.RemoteAddr = "192.0.2.1:4000"
+	w := httptest.NewRecorder()
+	start := time.Now()
+	httpLogin(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q; want 429 and a retry time", w.Code, w.Header().Get("Retry-After"))
+	}
+	if took := time.Since(start); took > 50*time.Millisecond {
+		t.Errorf("refusal took %v: the password was hashed", took)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Passwords are hashed with their account's salt
+// ---------------------------------------------------------------------------
+
+func TestHashPassword(t *testing.T) {
+	salt1 := []byte("0123456789abcdef")
+	salt2 := []byte("fedcba9876543210")
+	hash := hashPassword("correct horse", salt1)
+	if len(hash) != HASH_LEN {
+		t.Fatalf("hash of %d bytes, want %d", len(hash), HASH_LEN)
+	}
+	if !bytes.Equal(hashPassword("correct horse", salt1), hash) {
+		t.Error("one password and salt hash differently")
+	}
+	if bytes.Equal(hashPassword("correct horse", salt2), hash) {
+		t.Error("another salt gives the same hash")
+	}
+	if bytes.Equal(hashPassword("correct horsf", salt1), hash) {
+		t.Error("another password gives the same hash")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Sessions outlast a restart, until they expire or the player logs out, and
+// the sessions file holds no token
+// ---------------------------------------------------------------------------
+
+func TestSessionsSaved(t *testing.T) {
+	VerboseGlobal = false
+	t.Chdir(t.TempDir())
+	Sessions = make(map[string]session_t)
+
+	w := httptest.NewRecorder()
+	startSession(w, "alice")
+	cookie := w.Result().Cookies()[0]
+	AccountsMu.Lock()
+	Sessions[sessionKey("stale")] = session_t{"bob", time.Now().Add(-time.Second)}
+	saveSessions()
+	AccountsMu.Unlock()
+
+	saved, err := os.ReadFile(SESSIONS_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), cookie.Value) || strings.Contains(string(saved), "bob") {
+		t.Errorf("sessions file holds a token or an expired session: %s", saved)
+	}
+
+	// Restart
+	Sessions = make(map[string]session_t)
+	loadSessions()
+	r := httptest.NewRequest(http.MethodGet, "/account/me", nil)
+	r.AddCookie(cookie)
+	if name := sessionAccount(r); name != "alice" {
+		t.Fatalf("logged in as %q after a restart, want alice", name)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/account/logout", nil)
+	r.AddCookie(cookie)
+	httpLogout(httptest.NewRecorder(), r)
+	Sessions = make(map[string]session_t)
+	loadSessions()
+	r = httptest.NewRequest(http.MethodGet, "/account/me", nil)
+	r.AddCookie(cookie)
+	if name := sessionAccount(r); name != "" {
+		t.Errorf("logged in as %q after logging out and a restart", name)
+	}
+}
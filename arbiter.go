@@ -0,0 +1,94 @@
+// ---------------------------------------------------------------------------
+// Arbitration of near-simultaneous flips.
+//
+// Moves are normally played in the order they reach the game manager, so the
+// player with the quicker connection wins every race for a tile. In
+// arbitration mode each flip is instead held for the arbitration window, and
+// held flips are played in the order they were made: their time of arrival
+// less the player's one-way latency (half the round-trip time measured on
+// their connection). A flip made at time t arrives by t plus the window, so
+// once the earliest held flip is a window old, no flip made before it can
+// still be on its way.
+//
+// Compensation is capped at the window: a slow link gains no more than that,
+// however its round-trip time is measured (or inflated). Bots are local and
+// have no latency.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// The arbitration window for new games, or 0 to play moves as they arrive
+var Arbitrate time.Duration
+
+type heldFlip_t struct {
+	p      int
+	idx    int
+	madeAt time.Time // Arrival less the player's latency
+}
+
+// ---------------------------------------------------------------------------
+// A flip move is F, the player and the tile (three digits), then from a
+// client "/" and the client's one-way latency in microseconds.
+//
+// Returns: the tile, and the latency (0 if not given)
+// ---------------------------------------------------------------------------
+
+func flipMove(msg string) (int, time.Duration) {
+	idx, _ := strconv.Atoi(msg[2:5])
+	latency := time.Duration(0)
+	if len(msg) > 6 && msg[5] == '/' {
+		us, _ := strconv.Atoi(msg[6:])
+		latency = time.Duration(us) * time.Microsecond
+	}
+	return idx, latency
+}
+
+// ---------------------------------------------------------------------------
+// Hold a flip that has just arrived, keeping held flips in the order made
+// ---------------------------------------------------------------------------
+
+func (game *game_t) holdFlip(p, idx int, latency time.Duration) {
+	if latency > game.arbitrate {
+		latency = game.arbitrate
+	}
+	flip := heldFlip_t{p, idx, game.clock.Now().Add(-latency)}
+
+	i := len(game.held)
+	for i > 0 && game.held[i-1].madeAt.After(flip.madeAt) {
+		i--
+	}
+	game.held = append(game.held, heldFlip_t{})
+	copy(game.held[i+1:], game.held[i:])
+	game.held[i] = flip
+}
+
+// ---------------------------------------------------------------------------
+// When the earliest held flip is due to be played (zero if none are held)
+// ---------------------------------------------------------------------------
+
+func (game *game_t) nextRelease() time.Time {
+	if len(game.held) == 0 {
+		return time.Time{}
+	}
+	return game.held[0].madeAt.Add(game.arbitrate)
+}
+
+// ---------------------------------------------------------------------------
+// Returns: the held flips now due to be played, in the order they were made
+// ---------------------------------------------------------------------------
+
+func (game *game_t) releaseFlips() []heldFlip_t {
+	now := game.clock.Now()
+	n := 0
+	for n < len(game.held) && !now.Before(game.held[n].madeAt.Add(game.arbitrate)) {
+		n++
+	}
+	due := append([]heldFlip_t{}, game.held[:n]...)
+	game.held = game.held[n:]
+	return due
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Flip moves, with and without the client's latency
+// ---------------------------------------------------------------------------
+
+func TestFlipMove(t *testing.T) {
+	tests := []struct {
+		msg     string
+		idx     int
+		latency time.Duration
+	}{
+		{"F1007", 7, 0},
+		{"F2012/", 12, 0},
+		{"F1003/2500", 3, 2500 * time.Microsecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			idx, latency := flipMove(tt.msg)
+			if idx != tt.idx || latency != tt.latency {
+				t.Errorf("%d, %v; want %d, %v", idx, latency, tt.idx, tt.latency)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Held flips are played in the order made, once the window has passed since
+// each was made, with latency allowed for no more than the window
+// ---------------------------------------------------------------------------
+
+func TestReleaseFlips(t *testing.T) {
+	ms := time.Millisecond
+	type arrival struct {
+		at      time.Duration // From the start
+		p, idx  int
+		latency time.Duration
+	}
+
+	tests := []struct {
+		name     string
+		arrivals []arrival
+		release  time.Duration // When the held flips are released
+		played   []int         // The tiles played, in order
+		next     time.Duration // When the next release is due (-1 for none held)
+	}{
+		{"arrival order", []arrival{{0, 1, 4, 0}, {10 * ms, 2, 5, 0}}, 50 * ms, []int{4}, 60 * ms},
+		{"latency first", []arrival{{0, 1, 4, 0}, {10 * ms, 2, 5, 20 * ms}}, 45 * ms, []int{5}, 50 * ms},
+		{"latency capped", []arrival{{0, 1, 4, 45 * ms}, {10 * ms, 2, 5, 500 * ms}}, 50 * ms, []int{4, 5}, -1},
+		{"not yet", []arrival{{0, 1, 4, 0}}, 49 * ms, nil, 50 * ms},
+		{"all due", []arrival{{0, 1, 4, 0}, {10 * ms, 2, 5, 0}}, 60 * ms, []int{4, 5}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, _ := testGame(2, 0, 2, []int{1, 1})
+			game.arbitrate = 50 * ms
+			clock := game.clock.(*virtualClock_t)
+			start := clock.now
+
+			for _, a := range tt.arrivals {
+				clock.advanceTo(start.Add(a.at))
+				game.holdFlip(a.p, a.idx, a.latency)
+			}
+			clock.advanceTo(start.Add(tt.release))
+			played := []int(nil)
+			for _, flip := range game.releaseFlips() {
+				played = append(played, flip.idx)
+			}
+			if !reflect.DeepEqual(played, tt.played) {
+				t.Errorf("played %v, want %v", played, tt.played)
+			}
+
+			next := game.nextRelease()
+			if tt.next < 0 {
+				if !next.IsZero() {
+					t.Errorf("next release at %v, want none", next.Sub(start))
+				}
+			} else if !next.Equal(start.Add(tt.next)) {
+				t.Errorf("next release at %v, want %v", next.Sub(start), tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// ---------------------------------------------------------------------------
+// Adaptive difficulty for memBot.
+//
+// An adaptive bot profile keeps the human near a target win rate. After
+// each game against a human, the game manager passes the result here, and
+// the bot's speed (slowPc, scaling its reaction times) and memory (memPc)
+// are nudged towards the target within the profile's bounds. Optionally the
+// bot also eases off within a game while it is well ahead.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+type adapt_t struct {
+	targetPc     int // Human win rate to aim for
+	window       int // Number of recent games to measure the win rate over
+	slowStepPc   int // Change to slowPc per game
+	memStepPc    int // Change to memPc per game
+	minSlowPc    int
+	maxSlowPc    int
+	minMemPc     int
+	maxMemPc     int
+	inGame       bool // Also adjust speed during a game
+	inGameStepPc int  // Extra slowPc for each pair the bot leads by, beyond one
+
+	results []float64 // Recent human results: 1 win, 0.5 tie, 0 loss
+	log     []string  // Every adjustment made
+}
+
+// ---------------------------------------------------------------------------
+// Return a copy of a profile for one game slot, so that adaptive state is
+// not shared between slots.
+// ---------------------------------------------------------------------------
+
+func (prof botProfile_t) instance() *botProfile_t {
+	if prof.adapt != nil {
+		a := *prof.adapt
+		a.results = nil
+		a.log = nil
+		prof.adapt = &a
+	}
+	return &prof
+}
+
+// ---------------------------------------------------------------------------
+// Adjust the bot after a game against a human. humanResult is 1 if the human
+// won, 0.5 for a tie and 0 if the bot won.
+// ---------------------------------------------------------------------------
+
+func (prof *botProfile_t) adaptAfterGame(humanResult float64, gameNum int) {
+	a := prof.adapt
+	if a == nil {
+		return
+	}
+
+	a.results = append(a.results, humanResult)
+	if len(a.results) > a.window {
+		a.results = a.results[len(a.results)-a.window:]
+	}
+
+	total := 0.0
+	for _, r := range a.results {
+		total += r
+	}
+	ratePc := int(100 * total / float64(len(a.results)))
+
+	slowPc := prof.slowPc
+	memPc := prof.memPc
+	if ratePc < a.targetPc { // Human losing - make the bot easier
+		slowPc += a.slowStepPc
+		memPc -= a.memStepPc
+	} else if ratePc > a.targetPc { // Human winning - make the bot harder
+		slowPc -= a.slowStepPc
+		memPc += a.memStepPc
+	}
+	slowPc = clampInt(slowPc, a.minSlowPc, a.maxSlowPc)
+	memPc = clampInt(memPc, a.minMemPc, a.maxMemPc)
+
+	if slowPc == prof.slowPc && memPc == prof.memPc {
+		return
+	}
+
+	entry := fmt.Sprintf("game %d: human win rate %d%% (target %d%%) - slow%% %d -> %d, memory%% %d -> %d",
+		gameNum, ratePc, a.targetPc, prof.slowPc, slowPc, prof.memPc, memPc)
+	a.log = append(a.log, entry)
+	log.Println("ADAPT", prof.Name, entry)
+
+	prof.slowPc = slowPc
+	prof.memPc = memPc
+}
+
+// ---------------------------------------------------------------------------
+// Speed to play at during a game, given how many pairs the bot leads by
+// ---------------------------------------------------------------------------
+
+func (prof *botProfile_t) inGameSlowPc(baseSlowPc, lead int) int {
+	a := prof.adapt
+	if a == nil || !a.inGame || lead <= 1 {
+		return baseSlowPc
+	}
+	return clampInt(baseSlowPc+(lead-1)*a.inGameStepPc, a.minSlowPc, a.maxSlowPc)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,264 @@
+// ---------------------------------------------------------------------------
+// An expert strategy for memBot.
+//
+// The greedy strategy in botChoose plays a known pair when it has one, tries
+// every guzump, and otherwise flips at random. The expert does the same sure
+// things, but when it must reveal a tile it weighs the expected value of its
+// options:
+//  - the chance the flip makes (or sets up) a pair for the bot,
+//  - the chance the revealed tile hands the opponent a guzump or a pair,
+//    estimated from how recently the opponent could have seen each tile,
+//  - the odds of winning the race for that pair, from the bot's own
+//    reaction times and the opponent's reaction times measured this game.
+//
+// The expected values assume pairs. In games played with larger sets the
+// expert plays as the greedy strategy does.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const STRAT_GREEDY int = 0
+const STRAT_EXPERT int = 1
+
+// Assumed rate at which the opponent forgets tiles, in flips per half-life
+const EXPERT_OPP_FLIP_HALF_LIFE = 30.0
+
+// Assumed opponent reaction time until enough flips have been measured
+const EXPERT_OPP_PRIOR_MEAN_MS = 700.0
+const EXPERT_OPP_PRIOR_SD_MS = 250.0
+const EXPERT_OPP_MIN_SAMPLES = 3
+
+// ---------------------------------------------------------------------------
+// Running estimate of the opponent's reaction time: the time from the last
+// board update to each opponent flip. With several opponents, they are
+// measured together.
+// ---------------------------------------------------------------------------
+
+type oppModel_t struct {
+	n      int
+	meanMs float64
+	m2     float64
+}
+
+func (opp *oppModel_t) observe(d time.Duration) {
+	ms := float64(d.Milliseconds())
+	opp.n++
+	delta := ms - opp.meanMs
+	opp.meanMs += delta / float64(opp.n)
+	opp.m2 += delta * (ms - opp.meanMs)
+}
+
+func (opp *oppModel_t) reaction() (float64, float64) {
+	if opp.n < EXPERT_OPP_MIN_SAMPLES {
+		return EXPERT_OPP_PRIOR_MEAN_MS, EXPERT_OPP_PRIOR_SD_MS
+	}
+	return opp.meanMs, math.Sqrt(opp.m2 / float64(opp.n-1))
+}
+
+// ---------------------------------------------------------------------------
+// Probability that the bot, reacting with the given reaction time, flips
+// before the opponent. Both reaction times are taken as normally
+// distributed, so their difference is too.
+// ---------------------------------------------------------------------------
+
+func raceOdds(mine reaction_t, opp *oppModel_t) float64 {
+	oppMean, oppSd := opp.reaction()
+	sd := math.Sqrt(oppSd*oppSd + float64(mine.sdMs*mine.sdMs))
+	if sd == 0 {
+		if float64(mine.meanMs) < oppMean {
+			return 1
+		}
+		return 0
+	}
+	z := (oppMean - float64(mine.meanMs)) / sd
+	return 0.5 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+// ---------------------------------------------------------------------------
+// Probability that the opponent still remembers a tile, given the flips
+// since it was last seen. A tile that has never been seen is unknown.
+// ---------------------------------------------------------------------------
+
+func (tile *botTile_t) oppRecall(flipCnt int) float64 {
+	if tile.seenAt.IsZero() {
+		return 0
+	}
+	return math.Pow(0.5, float64(flipCnt-tile.seenFlip)/EXPERT_OPP_FLIP_HALF_LIFE)
+}
+
+// ---------------------------------------------------------------------------
+// Expert bot chooses tile to flip. Like botChoose, the choice depends only
+// on the bot's memory of the board, what it has measured of its opponent,
+// and its random numbers. The reason for the choice is noted in dec.
+//
+// Returns: tile index to flip next, and the kind of action
+// ---------------------------------------------------------------------------
+
+func botChooseExpert(p int, botmem botmem_t, setSize int, rng *rand.Rand, prof *botProfile_t, opp *oppModel_t, flipCnt int, dec *botDecision_t) (int, int) {
+	if setSize != 2 {
+		return botChoose(p, botmem, setSize, rng, dec)
+	}
+
+	// Sort the tiles: face-up, face-down unknown, face-down known by value
+	myUp := []int{}
+	oppUp := []int{}
+	teamUp := []int{}
+	unknown := []int{}
+	known := make(map[int][]int)
+	faceDown := []int{}
+
+	for t, tile := range botmem {
+		if tile.disp == FACEDOWN {
+			faceDown = append(faceDown, t)
+		}
+		if tile.disp == FACEUP_ME {
+			myUp = append(myUp, t)
+		} else if tile.disp == FACEUP_OPP {
+			oppUp = append(oppUp, t)
+		} else if tile.disp == FACEUP_TEAM {
+			teamUp = append(teamUp, t)
+		} else if tile.disp == FACEDOWN && tile.val == NOVAL {
+			unknown = append(unknown, t)
+		} else if tile.disp == FACEDOWN {
+			known[tile.val] = append(known[tile.val], t)
+		}
+	}
+
+	singles := []int{}
+	for _, tiles := range known {
+		if len(tiles) == 1 {
+			singles = append(singles, tiles[0])
+		}
+	}
+
+	if len(faceDown) == 0 {
+		return 0, ACT_NONE
+	}
+
+	// Chances that a newly revealed tile's mate is known to us, and known to
+	// the opponent, and that we win the race to use it.
+	u := float64(len(unknown))
+	qUs := 0.0
+	qOpp := 0.0
+	if u > 0 {
+		qUs = float64(len(singles)) / u
+		for _, t := range unknown {
+			qOpp += botmem[t].oppRecall(flipCnt)
+		}
+		qOpp /= u
+	}
+	race := raceOdds(prof.react[ACT_SECOND], opp)
+
+	// -------------------------------------------------------------------------
+	// Second flip: take a known match, otherwise reveal a new tile only if
+	// that is worth more than the information it gives away.
+	// -------------------------------------------------------------------------
+
+	if len(myUp) == 1 {
+		myVal := botmem[myUp[0]].val
+		if mates := known[myVal]; len(mates) > 0 {
+			dec.Reason = "expert: known match"
+			dec.Candidates = mates
+			return mates[0], ACT_SECOND
+		}
+
+		if len(unknown) == 0 {
+			dec.Reason = "expert: no unknown tiles left"
+			dec.Candidates = faceDown
+			return faceDown[rng.Intn(len(faceDown))], ACT_SECOND
+		}
+
+		evReveal := expertRevealEV(u, qUs, qOpp, race)
+		if evReveal < 0 && len(singles) > 0 {
+			dec.Reason = fmt.Sprintf("expert: safe known tile (reveal EV %.2f)", evReveal)
+			dec.Candidates = singles
+			return singles[rng.Intn(len(singles))], ACT_SECOND
+		}
+
+		dec.Reason = fmt.Sprintf("expert: reveal new tile (reveal EV %.2f)", evReveal)
+		dec.Candidates = unknown
+		return unknown[rng.Intn(len(unknown))], ACT_SECOND
+	}
+
+	// -------------------------------------------------------------------------
+	// Guzump the opponent's exposed tile if we know its mate. Losing the race
+	// costs nothing: our flip of a face-up tile is simply ignored.
+	// -------------------------------------------------------------------------
+
+	for _, t := range oppUp {
+		if mates := known[botmem[t].val]; len(mates) > 0 {
+			dec.Reason = fmt.Sprintf("expert: guzump (race odds %.2f)", raceOdds(prof.react[ACT_GUZUMP], opp))
+			dec.Candidates = mates
+			return mates[0], ACT_GUZUMP
+		}
+	}
+
+	// A teammate's face-up tile is ours to complete, with no race to lose
+	for _, t := range teamUp {
+		if mates := known[botmem[t].val]; len(mates) > 0 {
+			dec.Reason = "expert: complete teammate's pair"
+			dec.Candidates = mates
+			return mates[0], ACT_SECOND
+		}
+	}
+
+	// -------------------------------------------------------------------------
+	// First flip: a known pair, or else the better of revealing a new tile
+	// first (it may match a tile we know, but is exposed alone to a guzump)
+	// or a known tile first (giving nothing away until the second flip).
+	// -------------------------------------------------------------------------
+
+	for _, tiles := range known {
+		if len(tiles) >= 2 {
+			dec.Reason = "expert: known pair"
+			dec.Candidates = tiles
+			return tiles[rng.Intn(2)], ACT_FIRST
+		}
+	}
+
+	if len(unknown) == 0 {
+		dec.Reason = "expert: no unknown tiles left"
+		dec.Candidates = faceDown
+		return faceDown[rng.Intn(len(faceDown))], ACT_FIRST
+	}
+	if len(singles) == 0 {
+		dec.Reason = "expert: nothing known, new tile first"
+		dec.Candidates = unknown
+		return unknown[rng.Intn(len(unknown))], ACT_FIRST
+	}
+
+	guzumped := qOpp * (1 - race)
+	evUnknownFirst := qUs*(1-guzumped) - (1-qUs)*guzumped
+	if u > 1 {
+		evUnknownFirst += (1 - qUs) * math.Max(expertRevealEV(u-1, qUs, qOpp, race), 0)
+	}
+	evKnownFirst := math.Max(expertRevealEV(u, qUs, qOpp, race), 0)
+
+	if evKnownFirst > evUnknownFirst {
+		dec.Reason = fmt.Sprintf("expert: known tile first (EV %.2f vs %.2f)", evKnownFirst, evUnknownFirst)
+		dec.Candidates = singles
+		return singles[rng.Intn(len(singles))], ACT_FIRST
+	}
+
+	dec.Reason = fmt.Sprintf("expert: new tile first (EV %.2f vs %.2f)", evUnknownFirst, evKnownFirst)
+	dec.Candidates = unknown
+	return unknown[rng.Intn(len(unknown))], ACT_FIRST
+}
+
+// ---------------------------------------------------------------------------
+// Expected value of revealing one of u unknown tiles as a second flip: it
+// matches our face-up tile with chance 1/u. Otherwise it is new information
+// for both players, worth something if we know its mate and win the race,
+// and a loss if the opponent knows its mate and wins.
+// ---------------------------------------------------------------------------
+
+func expertRevealEV(u, qUs, qOpp, race float64) float64 {
+	return 1/u + (1-1/u)*(qUs*race-qOpp*(1-race))
+}
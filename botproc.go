@@ -0,0 +1,279 @@
+// ---------------------------------------------------------------------------
+// A memory-game bot run as a child process, so that bots can be written in
+// any language. The server talks to the bot with a line protocol on the
+// child's stdin and stdout (in the spirit of UCI for chess engines).
+//
+// Server to bot (one command per line):
+//
+//    newgame <tiles> <player> <players> <teams> <setsize> <mode>
+//                                A new game has started (teams is 0 unless
+//                                players are in teams, setsize tiles of one
+//                                value make a set, and mode is realtime or
+//                                turns). Reply "ready".
+//    reveal <tile> <value> me    This bot's flip revealed a tile
+//    reveal <tile> <value> opp   An opponent's flip revealed a tile
+//    reveal <tile> <value> team  A teammate's flip revealed a tile
+//    turn <player>               It is this player's turn (turn-based games
+//                                only; flips out of turn are ignored)
+//    hide <tile> ...             Unmatched tiles were turned face down
+//    remove <tile> ...           A matched set was taken off the board
+//    gameover                    No face-down tiles remain
+//    quit                        Exit now
+//
+// Bot to server (one command per line):
+//
+//    ready                       Bot is ready to play
+//    flip <tile>                 Flip a tile (may be sent at any time)
+//    pass                        No move this time - keeps the bot alive
+//
+// Tiles are numbered from 0. A bot that fails to start, exits, or is silent
+// for longer than the move timeout forfeits the game. The bot's goroutine
+// still follows the board to the end of the game so that the game manager
+// is never left waiting on it.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PROCBOT_START_TIMEOUT = 5 * time.Second
+const PROCBOT_MOVE_TIMEOUT = 30 * time.Second
+const PROCBOT_QUIT_TIMEOUT = 2 * time.Second
+
+// ---------------------------------------------------------------------------
+// Run one game for an external bot. Called in place of memBot when the
+// player has a bot command line.
+// ---------------------------------------------------------------------------
+
+func procBot(p player_t, rules rules_t, verbose bool) {
+	forfeited := false
+	forfeit := func(reason string) {
+		if forfeited {
+			return
+		}
+		forfeited = true
+		log.Println("Bot", p.Num, "forfeits the game:", reason)
+		p.sendMove("X", "")
+	}
+
+	cmd, stdin, lines, err := startBotProcess(p.bot.cmd)
+	if err != nil {
+		forfeit(err.Error())
+	} else {
+		defer stopBotProcess(cmd, stdin, lines)
+	}
+
+	send := func(line string) {
+		if forfeited {
+			return
+		}
+		if verbose {
+			log.Printf("Bot %d <- %s\n", p.Num, line)
+		}
+		_, err := io.WriteString(stdin, line+"\n")
+		if err != nil {
+			forfeit(err.Error())
+		}
+	}
+
+	// -------------------------------------------------------------------------
+	// Handshake - the bot must answer "ready" within the start timeout
+	// -------------------------------------------------------------------------
+
+	mode := "realtime"
+	if rules.turnBased {
+		mode = "turns"
+	}
+	send(fmt.Sprintf("newgame %d %d %d %d %d %s", rules.tMax, p.Num, rules.seats, rules.teams, rules.setSize, mode))
+
+	timeout := time.NewTimer(PROCBOT_START_TIMEOUT)
+	defer timeout.Stop()
+
+	if !forfeited {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				forfeit("bot process exited during start up")
+			} else if strings.TrimSpace(line) != "ready" {
+				forfeit("expected ready, got " + line)
+			}
+		case <-timeout.C:
+			forfeit("bot did not answer ready")
+		}
+	}
+
+	// -------------------------------------------------------------------------
+	// Play - relay board updates to the bot and bot flips to the game
+	// -------------------------------------------------------------------------
+
+	removedCnt := 0
+	timeout.Reset(PROCBOT_MOVE_TIMEOUT)
+
+	for {
+		if forfeited {
+			lines = nil
+		}
+
+		select {
+		case b := <-p.board:
+			cmd, removed := boardCommand(b)
+			if cmd != "" {
+				send(cmd)
+			}
+			removedCnt += removed
+
+			if removedCnt >= rules.tMax-rules.tMax%rules.setSize {
+				send("gameover")
+				p.sendMove("N", "")
+				if VerboseGlobal {
+					log.Println("Bot", p.Num, "game over. Bot terminated.")
+				}
+				return
+			}
+
+		case line, ok := <-lines:
+			if !ok {
+				forfeit("bot process exited")
+				continue
+			}
+			timeout.Reset(PROCBOT_MOVE_TIMEOUT)
+
+			if verbose {
+				log.Printf("Bot %d -> %s\n", p.Num, line)
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "flip" {
+				idx, err := strconv.Atoi(fields[1])
+				if err != nil || idx < 0 || idx >= rules.tMax {
+					log.Println("Bot", p.Num, "sent bad tile", fields[1])
+					continue
+				}
+				p.sendMove("F", fmt.Sprintf("%03d", idx))
+			} else if len(fields) == 1 && fields[0] == "pass" {
+				continue
+			} else if VerboseGlobal {
+				log.Println("Bot", p.Num, "ignored line [", line, "]")
+			}
+
+		case <-timeout.C:
+			forfeit("no move within " + PROCBOT_MOVE_TIMEOUT.String())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// The protocol command for a board message ("" if the bot is not told of
+// it), and how many tiles the message takes off the board
+// ---------------------------------------------------------------------------
+
+func boardCommand(b string) (string, int) {
+	if b == "" {
+		return "", 0
+	}
+	switch b[0] {
+	case 'F', 'O', 'T': // (F)lipped by this bot, (O)pponent or (T)eammate flipped tile
+		if len(b) < 7 {
+			return "", 0
+		}
+		idx1, _ := strconv.Atoi(b[1:4])
+		idx2, _ := strconv.Atoi(b[4:7])
+		who := map[byte]string{'F': "me", 'O': "opp", 'T': "team"}[b[0]]
+		return fmt.Sprintf("reveal %d %d %s", idx1, idx2, who), 0
+	case 'H': // (H)ide unmatched tiles
+		tiles, _ := msgTiles(b)
+		return "hide" + tileList(tiles), 0
+	case 'R': // (R)emove matched tiles
+		tiles, _ := msgTiles(b)
+		return "remove" + tileList(tiles), len(tiles)
+	case 'P': // (P)layer's turn
+		return "turn " + b[1:], 0
+	}
+	return "", 0
+}
+
+// ---------------------------------------------------------------------------
+// Tile indexes as the protocol lists them, each preceded by a space
+// ---------------------------------------------------------------------------
+
+func tileList(tiles []int) string {
+	list := ""
+	for _, idx := range tiles {
+		list += " " + strconv.Itoa(idx)
+	}
+	return list
+}
+
+// ---------------------------------------------------------------------------
+// Launch the bot command. Lines written by the bot to stdout are delivered
+// on the returned channel, which is closed when the bot's stdout closes.
+// The bot's stderr is passed through to the server's stderr.
+// ---------------------------------------------------------------------------
+
+func startBotProcess(cmdLine string) (*exec.Cmd, io.WriteCloser, chan string, error) {
+	args := strings.Fields(cmdLine)
+	if len(args) == 0 {
+		return nil, nil, nil, fmt.Errorf("empty bot command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	return cmd, stdin, lines, nil
+}
+
+// ---------------------------------------------------------------------------
+// Ask the bot to quit, and kill it if it will not go quietly. Unread output
+// is discarded so that the stdout reader can finish.
+// ---------------------------------------------------------------------------
+
+func stopBotProcess(cmd *exec.Cmd, stdin io.WriteCloser, lines chan string) {
+	go func() {
+		for range lines {
+		}
+	}()
+
+	io.WriteString(stdin, "quit\n")
+	stdin.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case <-exited:
+	case <-time.After(PROCBOT_QUIT_TIMEOUT):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
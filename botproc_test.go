@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Board messages, and the protocol commands an external bot is sent for them
+// ---------------------------------------------------------------------------
+
+func TestBoardCommand(t *testing.T) {
+	tests := []struct {
+		msg     string
+		cmd     string
+		removed int
+	}{
+		{"F0030071", "reveal 3 7 me", 0},
+		{"O0120042", "reveal 12 4 opp", 0},
+		{"T0000013", "reveal 0 1 team", 0},
+		{"H0030121", "hide 3 12", 0},
+		{"R0030121", "remove 3 12", 2},
+		{"R0010020032", "remove 1 2 3", 3},
+		{"P1", "turn 1", 0},
+		{"P2", "turn 2", 0},
+		{"F12", "", 0},
+		{"S1", "", 0},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			cmd, removed := boardCommand(tt.msg)
+			if cmd != tt.cmd || removed != tt.removed {
+				t.Errorf("%q, %d; want %q, %d", cmd, removed, tt.cmd, tt.removed)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A bot process in a turn-based game: it is told whose turn it is, flips on
+// its own turn, and is told the game is over when the last set goes
+// ---------------------------------------------------------------------------
+
+func TestProcBotTurns(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run a bot")
+	}
+	script := filepath.Join(t.TempDir(), "bot.sh")
+	err = os.WriteFile(script, []byte(`while read cmd arg rest; do
+	case "$cmd" in
+	newgame) echo ready ;;
+	turn) if [ "$arg" = 1 ]; then echo flip 0; fi ;;
+	quit) exit 0 ;;
+	esac
+done
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	VerboseGlobal = false
+	p := player_t{Num: 1, IsBot: true, bot: &botProfile_t{Name: "SCRIPT", cmd: sh + " " + script},
+		board: make(chan string, 10), move: make(chan string, 10)}
+	rules := rules_t{tMax: 2, seats: 2, setSize: 2, turnBased: true}
+	done := make(chan bool)
+	go func() {
+		procBot(p, rules, false)
+		close(done)
+	}()
+
+	p.board <- "P2"
+	p.board <- "P1"
+	select {
+	case move := <-p.move:
+		if move != "F1000" {
+			t.Fatalf("move %q, want F1000", move)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flip on the bot's turn")
+	}
+
+	p.board <- "R0000011"
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot still running after the game")
+	}
+	if move := <-p.move; move != "N1" {
+		t.Errorf("move %q, want N1", move)
+	}
+}
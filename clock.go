@@ -0,0 +1,39 @@
+// ---------------------------------------------------------------------------
+// Clocks for the game manager and bots.
+//
+// Games played by people run on the real clock. Simulated games (sim.go) run
+// on a virtual clock, which stands still until the simulator advances it to
+// the next thing due to happen, so that games take no real time at all while
+// every action still happens in the same order it would in real time.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"time"
+)
+
+type clock_t interface {
+	Now() time.Time
+}
+
+type realClock_t struct{}
+
+func (realClock_t) Now() time.Time {
+	return time.Now()
+}
+
+type virtualClock_t struct {
+	now time.Time
+}
+
+func (c *virtualClock_t) Now() time.Time {
+	return c.now
+}
+
+// Move the virtual clock forward (never back) to the given time
+func (c *virtualClock_t) advanceTo(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+}
@@ -0,0 +1,66 @@
+// ---------------------------------------------------------------------------
+// Fair-play rules for flips.
+//
+// Bots are held back by their reaction times, but a human's client (or a
+// script posing as one) can send flips as fast as it likes. So every player,
+// human or bot, is held to the same fair-play profile: a minimum interval
+// between their flips, and a limit on the flips they make in any burst
+// window. A flip that breaks the rules is refused, the player is sent an
+// Error saying why, and the foul is counted against them.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+type fairPlay_t struct {
+	minInterval time.Duration // Least time between a player's flips
+	burst       int           // Most flips by a player in any burst window (0 for no limit)
+	burstWindow time.Duration
+}
+
+// The profile for new games, set from the command line
+var FairPlay = fairPlay_t{minInterval: 80 * time.Millisecond, burst: 10, burstWindow: 2 * time.Second}
+
+// ---------------------------------------------------------------------------
+// Check a flip by player p against the game's fair-play profile. An allowed
+// flip is recorded against the limits; a refused one is counted as a foul.
+//
+// Returns: "" if the flip is allowed, otherwise the reason it is refused
+// ---------------------------------------------------------------------------
+
+func (game *game_t) checkFlip(p int) string {
+	now := game.clock.Now()
+	fp := &game.fairPlay
+
+	// Forget flips that have left the burst window
+	recent := game.flipTimes[p][:0]
+	for _, at := range game.flipTimes[p] {
+		if now.Sub(at) < fp.burstWindow {
+			recent = append(recent, at)
+		}
+	}
+	game.flipTimes[p] = recent
+
+	reason := ""
+	if !game.lastFlip[p].IsZero() && now.Sub(game.lastFlip[p]) < fp.minInterval {
+		reason = "Flips too fast"
+	} else if fp.burst > 0 && len(recent) >= fp.burst {
+		reason = "Too many flips"
+	}
+
+	if reason != "" {
+		game.fouls[p]++
+		if VerboseGlobal {
+			log.Println("Player", p, "foul:", reason)
+		}
+		return reason
+	}
+
+	game.lastFlip[p] = now
+	game.flipTimes[p] = append(recent, now)
+	return ""
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Flips against the fair-play profile: too soon after the last is refused,
+// as is one too many in the burst window, and the window moves on
+// ---------------------------------------------------------------------------
+
+func TestCheckFlip(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		flips   []time.Duration // When player 1 flips, from the start
+		reasons []string        // ... and the reason each is refused, if it is
+		fouls   int
+	}{
+		{"spaced", []time.Duration{0, 100 * ms, 200 * ms}, []string{"", "", ""}, 0},
+		{"too fast", []time.Duration{0, 50 * ms, 130 * ms}, []string{"", "Flips too fast", ""}, 1},
+		{"burst", []time.Duration{0, 100 * ms, 200 * ms, 300 * ms}, []string{"", "", "", "Too many flips"}, 1},
+		{"burst over", []time.Duration{0, 100 * ms, 200 * ms, 1000 * ms}, []string{"", "", "", ""}, 0},
+		{"refused not counted", []time.Duration{0, 10 * ms, 100 * ms, 200 * ms}, []string{"", "Flips too fast", "", ""}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, _ := testGame(2, 0, 2, []int{1, 1})
+			game.fairPlay = fairPlay_t{minInterval: 80 * ms, burst: 3, burstWindow: time.Second}
+			clock := game.clock.(*virtualClock_t)
+			start := clock.now
+
+			for f, at := range tt.flips {
+				clock.advanceTo(start.Add(at))
+				if reason := game.checkFlip(1); reason != tt.reasons[f] {
+					t.Errorf("flip %d at %v: %q, want %q", f, at, reason, tt.reasons[f])
+				}
+			}
+			if game.fouls[1] != tt.fouls || game.fouls[2] != 0 {
+				t.Errorf("fouls %v, want %d for player 1", game.fouls[1:], tt.fouls)
+			}
+		})
+	}
+}
@@ -11,10 +11,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 
 	"math/rand"
 )
@@ -23,11 +25,12 @@ import (
 // STRUCTURES & CONSTANTS
 // -------------------------------------------------------------------------
 
+// A tile's disp is FACEDOWN, face up by player p (p itself, 1 to MAX_SEATS),
+// or won by player p (p * WON_BY). A tile left over when a player forfeits
+// with several opponents is won by nobody (WON_BY_NOBODY).
 const FACEDOWN int = 0
-const FACEUP_P1 int = 1
-const FACEUP_P2 int = 2
-const WON_BY_P1 int = 11
-const WON_BY_P2 int = 22
+const WON_BY int = 11
+const WON_BY_NOBODY int = 99
 const NOVAL int = 0
 
 type tile_t struct {
@@ -36,11 +39,29 @@ type tile_t struct {
 }
 type tilearray_t []tile_t
 
-// -------------------------------------------------------------------------
-// GLOBALS
-// -------------------------------------------------------------------------
+const MIN_SET_SIZE int = 2 // Pairs
+const MAX_SET_SIZE int = 4 // Quads
+
+// In a turn-based game, a player who flips nothing for this long loses the
+// turn
+const TURN_TIMEOUT = 20 * time.Second
+
+// ... and a mismatch stays on show this long before the turn passes, if
+// unmatched tiles are otherwise never hidden
+const TURN_REVEAL = 2 * time.Second
+
+// ---------------------------------------------------------------------------
+// The rules of a game, as told to its bots
+// ---------------------------------------------------------------------------
+
+type rules_t struct {
+	tMax    int // Tiles on the board
+	seats   int // Players
+	teams   int // Teams, or 0
+	setSize int // Tiles of one value that make a set
 
-var game_wg sync.WaitGroup = sync.WaitGroup{}
+	turnBased bool // Players take turns, rather than racing
+}
 
 // -------------------------------------------------------------------------
 // Game Manager
@@ -54,89 +75,336 @@ func gameManager(game *game_t, verbose bool) {
 
 	var board = make(tilearray_t, game.Tmax)
 
+	if game.clock == nil {
+		game.clock = realClock_t{}
+	}
+
+	rttTicker := time.NewTicker(PingInterval)
+	defer rttTicker.Stop()
+
+	game.left = make([]bool, len(game.Players)+1)
+
 	// -------------------------------------------------------------------------
 	// PLay the game
 	// -------------------------------------------------------------------------
 
 	for {
+		// The lobby reads the game's counts, so they change under GamesMu
+		GamesMu.Lock()
 		game.GameCounter++
+		GamesMu.Unlock()
 		game.moveCounter = 0
 
-		initBoard(game.Tmax, board[:])
+		game.clearScores()
+
+		seed := rand.Int63()
+		initBoard(game.Tmax, game.SetSize, board[:], rand.New(rand.NewSource(seed)))
+		game.startReplay(newReplay(game, board[:], seed))
+
+		for _, player := range game.Players {
+			if player.IsBot {
+				game.bots.Add(1)
+				go func(player player_t) {
+					defer game.bots.Done()
+					runBot(player, game.rules(), verbose, game.clock)
+				}(player)
+			}
+		}
 
-		if game.P1.IsBot {
-			game_wg.Add(1)
-			go memBot(game.P1, game.Tmax, verbose, board[:])
+		// In a turn-based game the first turn moves round the table from
+		// game to game, and a player idle for too long loses the turn
+		var turnTimer *time.Timer
+		var turnTimeout <-chan time.Time
+		if game.TurnBased {
+			turnTimer = time.NewTimer(TURN_TIMEOUT)
+			turnTimeout = turnTimer.C
+			first := (game.GameCounter-1)%len(game.Players) + 1
+			startTurn(game, first)
+			if game.out[first] {
+				passTurn(game, board[:])
+			}
 		}
 
-		if game.P2.IsBot {
-			game_wg.Add(1)
-			go memBot(game.P2, game.Tmax, verbose, board[:])
+		// Play a flip, now or when released by arbitration. In a turn-based
+		// game, a set won keeps the turn, a mismatch loses it once everyone
+		// has seen it (when the tiles are hidden).
+		//
+		// Returns: true if the flip won the last set, which ends the game
+		playFlip := func(p, idx int) bool {
+			if game.TurnBased && !game.hasTurn(p) {
+				game.Players[p-1].send("ENot your turn")
+				return false
+			}
+			if idx < 0 || idx >= len(board) {
+				return false
+			}
+			flipTile(game, p, idx, board[:])
+			if isGameFinished(board[:]) {
+				return true
+			}
+
+			if game.TurnBased {
+				turnTimer.Reset(TURN_TIMEOUT)
+				revealMismatch(game, p, board[:])
+			}
+			return false
 		}
 
 	read_moves_loop:
 		for {
 
-			// Block until a (F)lip or a (N)o Move received from a player
+			// Block until a (F)lip or a (N)o Move received from a player.
+			// Every move is tagged with the number of the player who made it.
 			game.moveCounter++
+			var hideTimeout <-chan time.Time
+			if hideAt := game.nextHide(); !hideAt.IsZero() {
+				hideTimeout = time.After(hideAt.Sub(game.clock.Now()))
+			}
+			var releaseTimeout <-chan time.Time
+			if releaseAt := game.nextRelease(); !releaseAt.IsZero() {
+				releaseTimeout = time.After(releaseAt.Sub(game.clock.Now()))
+			}
+
+			var msg string
 			select {
-			case msg := <-game.P1.move:
-				if msg[0:1] == "F" {
-					idx, _ := strconv.Atoi(msg[1:4])
-					flipTile(game, 1, idx, board[:])
+			case msg = <-game.moves:
+			case <-hideTimeout:
+				if autoHide(game, board[:]) {
+					turnTimer.Reset(TURN_TIMEOUT)
 				}
-				if msg[0:1] == "N" {
-					if isGameFinished(board[:]) {
+				continue
+			case <-rttTicker.C:
+				sendLatencies(game)
+				continue
+			case <-releaseTimeout:
+				for _, flip := range game.releaseFlips() {
+					if !game.out[flip.p] && playFlip(flip.p, flip.idx) {
 						break read_moves_loop
 					}
 				}
-			case msg := <-game.P2.move:
-				if msg[0:1] == "F" {
-					idx, _ := strconv.Atoi(msg[1:4])
-					flipTile(game, 2, idx, board[:])
+				continue
+			case <-turnTimeout:
+				if !isGameFinished(board[:]) {
+					log.Println("Player", game.turn, "made no move in time and loses the turn")
+					passTurn(game, board[:])
+					turnTimer.Reset(TURN_TIMEOUT)
 				}
-				if msg[0:1] == "N" {
-					if isGameFinished(board[:]) {
-						fmt.Println("Game is finished")
-						break read_moves_loop
-					}
+				continue
+			}
+			p, _ := strconv.Atoi(msg[1:2])
+			if p < 1 || p > len(game.Players) {
+				log.Println("Move from unknown player [", msg, "]")
+				continue
+			}
+
+			if msg[0:1] == "F" && !game.out[p] {
+				idx, latency := flipMove(msg)
+				if game.TurnBased && !game.hasTurn(p) {
+					game.Players[p-1].send("ENot your turn")
+				} else if reason := game.checkFlip(p); reason != "" {
+					game.Players[p-1].send("E" + reason)
+				} else if game.arbitrate > 0 {
+					game.holdFlip(p, idx, latency)
+				} else if playFlip(p, idx) {
+					break read_moves_loop
+				}
+			}
+			if msg[0:1] == "N" {
+				if isGameFinished(board[:]) {
+					fmt.Println("Game is finished")
+					break read_moves_loop
+				}
+			}
+			if msg[0:1] == "X" && !game.Players[p-1].IsBot {
+				game.left[p] = true
+			}
+			// A player who leaves once every tile is won forfeits nothing
+			if msg[0:1] == "X" && !game.out[p] && !isGameFinished(board[:]) {
+				if forfeitGame(game, p, board[:]) {
+					break read_moves_loop
 				}
+				if game.TurnBased && p == game.turn {
+					passTurn(game, board[:])
+					turnTimer.Reset(TURN_TIMEOUT)
+				}
+			}
+			if msg[0:1] == "D" {
+				botDecision(game, msg[2:])
 			}
 
 			if verbose {
 				gameTextDisp(board[:])
 			}
 		}
+		if turnTimer != nil {
+			turnTimer.Stop()
+		}
 		fmt.Println("Waiting for bots to finish")
-		game_wg.Wait() // Wait for all bots to terminate
+		game.bots.Wait() // Wait for this game's bots to terminate
 		fmt.Println("All bots finished")
 
-		winner := gameWinner(board[:])
+		winner := game.winner(board[:])
+		GamesMu.Lock()
+		game.Won[winner]++
+		for p, fouls := range game.fouls {
+			game.Fouls[p] += fouls
+		}
+		GamesMu.Unlock()
+		game.replay.Fouls = game.fouls
+		adaptBots(game, winner)
+		game.replay.Winner = winner
+		game.replay.save(game.GameCounter)
+		game.record(board[:], winner)
 		if verbose {
 			fmt.Println("===========", game)
 			if winner == 0 {
 				fmt.Println("=========== Game tied ")
-			} else if winner == 1 {
-				game.P1won++
-				fmt.Println("=========== Game won by player 1 ")
+			} else if game.Teams != 0 {
+				fmt.Println("=========== Game won by team", winner)
 			} else {
-				game.P2won++
-				fmt.Println("=========== Game won by player 2 ")
+				fmt.Println("=========== Game won by player", winner)
 			}
 
-			fmt.Println("LEADERBOARD", game.P1won, "VS", game.P2won)
+			fmt.Println("LEADERBOARD", game.Won[1:], "TIED", game.Won[0])
 		}
-	} // Loop - play games indefinitely
+
+		GamesMu.Lock()
+		game.lobbyUpdate(LOBBY_FINISHED)
+		GamesMu.Unlock()
+
+		if game.deserted() {
+			break
+		}
+	} // Loop - play games until every human has left, or nobody is left to play
+
+	// Free the slot for a new game. Anyone still here has nobody to play.
+	GamesMu.Lock()
+	for _, player := range game.Players {
+		if player.queue != nil && !game.left[player.Num] {
+			player.queue.hangUp()
+		}
+	}
+	game.free()
+	GamesMu.Unlock()
 }
 
-func initBoard(tMax int, board tilearray_t) {
-	for _, tile := range board {
-		tile.disp = FACEDOWN
-		tile.val = NOVAL
+// ---------------------------------------------------------------------------
+// Returns: true once every human player has left the game, or fewer than two
+// sides (players or teams) have anyone left in it
+// ---------------------------------------------------------------------------
+
+func (game *game_t) deserted() bool {
+	humans := false
+	sides := make(map[int]bool)
+	for _, player := range game.Players {
+		if player.IsBot || !game.left[player.Num] {
+			sides[game.sideOf(player.Num)] = true
+			humans = humans || !player.IsBot
+		}
+	}
+	return !humans || len(sides) < 2
+}
+
+// ---------------------------------------------------------------------------
+// Clear the per-game record of forfeits, guzumps, unmatched tiles, fouls and
+// flips (including any held for arbitration) before a game starts. Players
+// who have left the game are out of it from the start.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) clearScores() {
+	game.out = make([]bool, len(game.Players)+1)
+	copy(game.out, game.left)
+	game.guzumps = make([]int, len(game.Players)+1)
+	game.hideAt = make([]time.Time, len(game.Players)+1)
+	game.fouls = make([]int, len(game.Players)+1)
+	game.flips = make([]int, len(game.Players)+1)
+	game.lastFlip = make([]time.Time, len(game.Players)+1)
+	game.flipTimes = make([][]time.Time, len(game.Players)+1)
+	game.held = nil
+}
+
+// ---------------------------------------------------------------------------
+// Start a bot goroutine for one game: an external bot if the player has a
+// command line, otherwise a memBot. Bots are deliberately not given the
+// board: they learn about it only through their board channel.
+// ---------------------------------------------------------------------------
+
+func runBot(p player_t, rules rules_t, verbose bool, clock clock_t) {
+	if p.bot.cmd != "" {
+		procBot(p, rules, verbose)
+	} else {
+		memBot(p, rules, verbose, clock)
+	}
+}
+
+func (game *game_t) rules() rules_t {
+	return rules_t{game.Tmax, len(game.Players), game.Teams, game.SetSize, game.TurnBased}
+}
+
+// ---------------------------------------------------------------------------
+// Send a move to the game manager, tagged with the player's number
+// ---------------------------------------------------------------------------
+
+func (p *player_t) sendMove(kind string, payload string) {
+	p.move <- fmt.Sprintf("%s%d%s", kind, p.Num, payload)
+}
+
+// ---------------------------------------------------------------------------
+// Let adaptive bots playing humans adjust to the result of a game. The
+// humans win if any one of them (or their team) wins. A team game in which
+// humans and bots play on the same team says nothing clear about the
+// humans, so bots do not adapt to it.
+// ---------------------------------------------------------------------------
+
+func adaptBots(game *game_t, winner int) {
+	humans := 0
+	humanSide := make(map[int]bool)
+	botSide := make(map[int]bool)
+	for _, player := range game.Players {
+		side := game.sideOf(player.Num)
+		if player.IsBot {
+			botSide[side] = true
+		} else {
+			humans++
+			humanSide[side] = true
+		}
+	}
+	if humans == 0 || humans == len(game.Players) {
+		return
+	}
+	for side := range humanSide {
+		if botSide[side] {
+			return
+		}
+	}
+
+	humanResult := 0.0
+	if winner == 0 {
+		humanResult = 0.5
+	} else if humanSide[winner] {
+		humanResult = 1
+	}
+
+	for p := range game.Players {
+		if game.Players[p].IsBot {
+			game.Players[p].bot.adaptAfterGame(humanResult, game.GameCounter)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Deal a new board. tMax should be a multiple of the set size, as any tiles
+// left over could never be matched.
+// ---------------------------------------------------------------------------
+
+func initBoard(tMax, setSize int, board tilearray_t, rng *rand.Rand) {
+	for t := range board {
+		board[t].disp = FACEDOWN
+		board[t].val = NOVAL
 	}
-	for v := 1; v < int(tMax/2)+1; v++ { // Half as many values as tiles
-		for t := 1; t < 3; t++ { // Two tiles per value
-			idx := rand.Intn(tMax)
+	for v := 1; v < int(tMax/setSize)+1; v++ { // One value per set of tiles
+		for t := 0; t < setSize; t++ { // setSize tiles per value
+			idx := rng.Intn(tMax)
 			for {
 				if board[idx].val == NOVAL {
 					board[idx].val = v
@@ -150,7 +418,9 @@ func initBoard(tMax int, board tilearray_t) {
 		}
 	}
 	//log.Println("Board initialised")
-	gameTextDisp(board[:])
+	if VerboseGlobal {
+		gameTextDisp(board[:])
+	}
 }
 
 func isGameFinished(board tilearray_t) bool {
@@ -162,22 +432,363 @@ func isGameFinished(board tilearray_t) bool {
 	return true
 }
 
-func gameWinner(board tilearray_t) int {
-	player1 := 0
+// ---------------------------------------------------------------------------
+// Tile states for player p
+// ---------------------------------------------------------------------------
+
+func isFaceUp(disp int) bool {
+	return disp >= 1 && disp <= MAX_SEATS
+}
+
+func isWon(disp int) bool {
+	return disp >= WON_BY
+}
+
+// ---------------------------------------------------------------------------
+// Sets won by each player (index 0 is unused)
+// ---------------------------------------------------------------------------
+
+func gameScores(board tilearray_t, seats, setSize int) []int {
+	sets := make([]int, seats+1)
 	for _, tile := range board {
-		if tile.disp == WON_BY_P1 {
-			player1++
-		} else if tile.disp == WON_BY_P2 {
-			player1--
+		if isWon(tile.disp) && tile.disp != WON_BY_NOBODY {
+			sets[tile.disp/WON_BY]++
+		}
+	}
+	for p := range sets {
+		sets[p] /= setSize
+	}
+	return sets
+}
+
+// ---------------------------------------------------------------------------
+// The side (player or team) with the most sets, or 0 if the lead is tied.
+// Sides that forfeited cannot win.
+// ---------------------------------------------------------------------------
+
+func gameWinner(scores []int, out []bool) int {
+	winner := 0
+	best := -1
+	for p := 1; p < len(scores); p++ {
+		if out != nil && out[p] {
+			continue
 		}
+		if scores[p] > best {
+			winner = p
+			best = scores[p]
+		} else if scores[p] == best {
+			winner = 0
+		}
+	}
+	return winner
+}
+
+// ---------------------------------------------------------------------------
+// Sets won by each side, and which sides are out: a team is out once all
+// of its players have forfeited.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) sideScores(board tilearray_t) ([]int, []bool) {
+	sets := gameScores(board, len(game.Players), game.SetSize)
+	if game.Teams == 0 {
+		return sets, game.out
 	}
 
-	if player1 > 0 {
-		return 1
-	} else if player1 < 0 {
-		return 2
+	scores := make([]int, game.Teams+1)
+	out := make([]bool, game.Teams+1)
+	for t := 1; t <= game.Teams; t++ {
+		out[t] = true
+	}
+	for p := 1; p <= len(game.Players); p++ {
+		scores[game.teamOf(p)] += sets[p]
+		if !game.out[p] {
+			out[game.teamOf(p)] = false
+		}
 	}
-	return 0
+	return scores, out
+}
+
+func (game *game_t) winner(board tilearray_t) int {
+	return gameWinner(game.sideScores(board))
+}
+
+// ---------------------------------------------------------------------------
+// Player p forfeits. The player's face-up tiles are turned down, and the
+// other players play on without them. Once only one side (player or team)
+// remains, every tile still on the board is awarded to it, so a two-player
+// game ends at once. Tiles are removed with the usual messages, which lets
+// the bots run to the end of the game as usual.
+//
+// Returns: true if the game is over
+// ---------------------------------------------------------------------------
+
+func forfeitGame(game *game_t, p int, board tilearray_t) bool {
+	game.out[p] = true
+	hideFaceUp(game, p, board)
+
+	remaining := []int{} // A player from each side still playing
+	sides := make(map[int]bool)
+	for q := 1; q <= len(game.Players); q++ {
+		if !game.out[q] && !sides[game.sideOf(q)] {
+			sides[game.sideOf(q)] = true
+			remaining = append(remaining, q)
+		}
+	}
+	if len(remaining) > 1 {
+		return false
+	}
+
+	last := 0
+	win := WON_BY_NOBODY
+	if len(remaining) == 1 {
+		last = remaining[0]
+		win = last * WON_BY
+	}
+
+	for idx1, tile1 := range board {
+		if isWon(tile1.disp) {
+			continue
+		}
+		set := []int{}
+		for idx2 := idx1; idx2 < len(board); idx2++ {
+			if board[idx2].val == tile1.val && !isWon(board[idx2].disp) {
+				set = append(set, idx2)
+			}
+		}
+		for _, idx := range set {
+			board[idx].disp = win
+		}
+		rm_str := tilesMsg("R", set, last)
+		sendBoards(game, 0, rm_str, rm_str)
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// Player p's face-up tiles, and whether they are all of one value
+// ---------------------------------------------------------------------------
+
+func faceUp(p int, board tilearray_t) ([]int, bool) {
+	up := []int{}
+	matching := true
+	for idx, tile := range board {
+		if tile.disp == p {
+			up = append(up, idx)
+			if tile.val != board[up[0]].val {
+				matching = false
+			}
+		}
+	}
+	return up, matching
+}
+
+// ---------------------------------------------------------------------------
+// Turn player p's face-up tiles face down, and advise all players
+// ---------------------------------------------------------------------------
+
+func hideFaceUp(game *game_t, p int, board tilearray_t) {
+	up, _ := faceUp(p, board)
+	if len(up) == 0 {
+		return
+	}
+	for _, idx := range up {
+		board[idx].disp = FACEDOWN
+	}
+	d_str := tilesMsg("H", up, p)
+	sendBoards(game, p, d_str, d_str)
+}
+
+// ---------------------------------------------------------------------------
+// Unmatched tiles may not be left face up, blocking the board: they are
+// hidden game.hideAfter after the flip that failed to match. After each
+// flip, the flipper's deadline is set or cleared; autoHide hides the tiles
+// of every player whose deadline has passed.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) startHideTimer(p int, board tilearray_t) {
+	game.hideAt[p] = time.Time{}
+	if _, matching := faceUp(p, board); !matching && game.hideAfter > 0 {
+		game.hideAt[p] = game.clock.Now().Add(game.hideAfter)
+	}
+}
+
+func (game *game_t) nextHide() time.Time {
+	next := time.Time{}
+	for _, at := range game.hideAt {
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next
+}
+
+// Returns: true if hiding a mismatch passed the turn
+func autoHide(game *game_t, board tilearray_t) bool {
+	passed := false
+	now := game.clock.Now()
+	for p, at := range game.hideAt {
+		if !at.IsZero() && !now.Before(at) {
+			game.hideAt[p] = time.Time{}
+			if game.TurnBased && p == game.turn {
+				passTurn(game, board)
+				passed = true
+			} else {
+				hideFaceUp(game, p, board)
+			}
+		}
+	}
+	return passed
+}
+
+// ---------------------------------------------------------------------------
+// In a turn-based game, give player p the turn, or end the turn of the
+// player who has it: their tiles are turned face down, and the turn passes
+// to the next player still in the game. The (P)layer's turn message is sent
+// to all. A player whose mismatch is on show no longer has the turn.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) hasTurn(p int) bool {
+	return p == game.turn && !game.revealing
+}
+
+func startTurn(game *game_t, p int) {
+	game.turn = p
+	game.revealing = false
+	p_str := fmt.Sprintf("P%d", p)
+	sendBoards(game, p, p_str, p_str)
+}
+
+// A mismatch stays on show until the tiles are hidden (see autoHide), and
+// only then does the turn pass
+func revealMismatch(game *game_t, p int, board tilearray_t) {
+	if _, matching := faceUp(p, board); matching {
+		return
+	}
+	game.revealing = true
+	if game.hideAt[p].IsZero() {
+		game.hideAt[p] = game.clock.Now().Add(TURN_REVEAL)
+	}
+}
+
+func passTurn(game *game_t, board tilearray_t) {
+	game.hideAt[game.turn] = time.Time{}
+	hideFaceUp(game, game.turn, board)
+
+	next := game.turn
+	for range game.Players {
+		next = next%len(game.Players) + 1
+		if !game.out[next] {
+			break
+		}
+	}
+	startTurn(game, next)
+}
+
+// ---------------------------------------------------------------------------
+// (H)ide and (R)emove messages list any number of tiles, three digits each,
+// followed by the player's number
+// ---------------------------------------------------------------------------
+
+func tilesMsg(kind string, tiles []int, p int) string {
+	msg := kind
+	for _, idx := range tiles {
+		msg += fmt.Sprintf("%03d", idx)
+	}
+	return msg + strconv.Itoa(p)
+}
+
+func msgTiles(msg string) ([]int, int) {
+	tiles := []int{}
+	for i := 1; i+3 < len(msg); i += 3 {
+		idx, _ := strconv.Atoi(msg[i : i+3])
+		tiles = append(tiles, idx)
+	}
+	p, _ := strconv.Atoi(msg[len(msg)-1:])
+	return tiles, p
+}
+
+// ---------------------------------------------------------------------------
+// Send a board message to one player. A human's messages are queued for
+// their socket writer, so the game never waits on a slow client; a bot's go
+// to its board channel.
+// ---------------------------------------------------------------------------
+
+func (player *player_t) send(msg string) {
+	if player.queue != nil {
+		player.queue.push(msg)
+	} else {
+		player.board <- msg
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Advise every player of a change to the board: player p is sent pmsg, p's
+// teammates tmsg, and the others msg. Spectators see the others' view, and
+// the change is recorded in the game's replay.
+// ---------------------------------------------------------------------------
+
+func sendBoards(game *game_t, p int, pmsg, msg string) {
+	sendTeamBoards(game, p, pmsg, msg, msg)
+}
+
+func sendTeamBoards(game *game_t, p int, pmsg, tmsg, msg string) {
+	for _, player := range game.Players {
+		if player.Num == p {
+			player.send(pmsg)
+		} else if game.teammates(p, player.Num) {
+			player.send(tmsg)
+		} else {
+			player.send(msg)
+		}
+	}
+	game.watchEvent(msg)
+}
+
+// ---------------------------------------------------------------------------
+// Tell human players still in the game, and spectators, every player's
+// round-trip time: (L)atencies in milliseconds in seat order, "-" for a bot
+// ---------------------------------------------------------------------------
+
+func sendLatencies(game *game_t) {
+	rtts := []string{}
+	for _, player := range game.Players {
+		if player.link == nil {
+			rtts = append(rtts, "-")
+		} else {
+			rtts = append(rtts, strconv.FormatInt(player.link.roundTrip().Milliseconds(), 10))
+		}
+	}
+
+	l_str := "L" + strings.Join(rtts, ",")
+	for _, player := range game.Players {
+		if !player.IsBot && !game.out[player.Num] {
+			player.send(l_str)
+		}
+	}
+	game.sendWatchers(l_str, false)
+}
+
+// ---------------------------------------------------------------------------
+// A bot has explained a decision. Record it in the replay, and pass it on
+// to any spectator who asked to see bot decisions, and to the bot's human
+// teammates, who share what it knows. Opponents never see it: it tells what
+// the bot remembers, and what it is about to flip.
+// ---------------------------------------------------------------------------
+
+func botDecision(game *game_t, decJson string) {
+	var dec botDecision_t
+	err := json.Unmarshal([]byte(decJson), &dec)
+	if err != nil {
+		log.Println("Bad bot decision:", err)
+		return
+	}
+	d_str := "D" + decJson
+	for _, player := range game.Players {
+		if !player.IsBot && game.teammates(dec.Bot, player.Num) {
+			player.send(d_str)
+		}
+	}
+	game.watchDecision(&dec, d_str)
 }
 
 func gameTextDisp(board tilearray_t) {
@@ -201,7 +812,13 @@ func gameTextDisp(board tilearray_t) {
 //
 // This function is synchronous, as no async update of board is permitted.
 //
-// Messages sent: (F)lip, (O)pponent flip, (H)ide tiles, (R)emove tiles
+// A player turns up tiles one at a time, trying to turn up a set of
+// game.SetSize tiles of one value. A tile of another value ends the attempt:
+// the player's tiles stay face up until the player's next flip.
+//
+// Messages sent: (F)lip, (O)pponent flip, (T)eammate flip, (H)ide tiles,
+// (R)emove tiles. Each ends with the number of the player who flipped (or,
+// for R, won the set).
 // ---------------------------------------------------------------------------
 
 func flipTile(game *game_t, p, flip_idx int, board tilearray_t) {
@@ -211,79 +828,78 @@ func flipTile(game *game_t, p, flip_idx int, board tilearray_t) {
 	if board[flip_idx].disp != FACEDOWN {
 		return
 	}
+	game.flips[p]++
 
-	// Determine set of previously upturned tiles
-	me_up1 := -1
-	me_up2 := -1
-	opp_up1 := -1
-
-	for idx, tile := range board {
-		if tile.disp == FACEUP_P1 || tile.disp == FACEUP_P2 {
-			if tile.disp == p {
-				if me_up1 == -1 {
-					me_up1 = idx
-				} else {
-					me_up2 = idx
-				}
-			} else {
-				if opp_up1 == -1 {
-					opp_up1 = idx
-				}
-			}
-		}
-	}
+	// Determine set of previously upturned tiles, and whether they are all
+	// of one value
+	me_up, matching := faceUp(p, board)
 
 	if VerboseGlobal {
-		log.Println("Player", p, "has face-up tiles", me_up1, me_up2)
+		log.Println("Player", p, "has face-up tiles", me_up)
 	}
 
-	// If current player has two tiles up, face them both down
-	if me_up1 >= 0 && me_up2 >= 0 {
-		board[me_up1].disp = FACEDOWN
-		board[me_up2].disp = FACEDOWN
-		d_str := fmt.Sprintf("H%03d%03d", me_up1, me_up2)
-		game.P1.board <- d_str
-		game.P2.board <- d_str
-		me_up1 = -1
-		me_up2 = -1
+	// If current player's tiles failed to make a set, face them all down
+	if !matching {
+		hideFaceUp(game, p, board)
+		me_up = []int{}
 	}
 
-	// Flip tile and advise both players of revealed tile value
+	// Flip tile and advise all players of revealed tile value
 	flip_val := board[flip_idx].val
 	board[flip_idx].disp = p // FACEUP
-	f_str := fmt.Sprintf("F%03d%03d", flip_idx, flip_val)
-	o_str := fmt.Sprintf("O%03d%03d", flip_idx, flip_val)
-	if p == 1 {
-		game.P1.board <- f_str
-		game.P2.board <- o_str
+	f_str := fmt.Sprintf("F%03d%03d%d", flip_idx, flip_val, p)
+	t_str := fmt.Sprintf("T%03d%03d%d", flip_idx, flip_val, p)
+	o_str := fmt.Sprintf("O%03d%03d%d", flip_idx, flip_val, p)
+	sendTeamBoards(game, p, f_str, t_str, o_str)
+
+	// Tiles that fail to make a set are hidden after a while, if the player's
+	// next flip does not hide them first
+	game.startHideTimer(p, board)
+
+	// Determine if flipped tile completes a set. A player with tiles up can
+	// only add to their own set. A player with no tiles up completes the set
+	// of any other player who has all but one of it face up: a teammate's
+	// set is won for the team, and an opponent's is a guzump.
+
+	set := []int{}
+	if len(me_up) > 0 {
+		if board[me_up[0]].val == flip_val && len(me_up)+1 == game.SetSize {
+			set = me_up // Normal win
+		}
 	} else {
-		game.P1.board <- o_str
-		game.P2.board <- f_str
-	}
-
-	// Determine if flipped tile is part of a matched pair.
-	// Note that a guzump is only possible if only upface tile is opponent's.
-
-	match_idx := -1
-	if me_up1 >= 0 {
-		if flip_val == board[me_up1].val {
-			match_idx = me_up1 // Normal two-tile win
+		for q := 1; q <= len(game.Players) && len(set) == 0; q++ {
+			if q == p {
+				continue
+			}
+			q_up := []int{}
+			for idx, tile := range board {
+				if tile.disp == q && tile.val == flip_val {
+					q_up = append(q_up, idx)
+				}
+			}
+			if len(q_up)+1 != game.SetSize {
+				continue
+			}
+			set = q_up
+			if !game.teammates(p, q) {
+				game.guzumps[p]++ // Guzump win
+			}
 		}
-	} else if opp_up1 >= 0 && flip_val == board[opp_up1].val {
-		match_idx = opp_up1 // Guzump win
 	}
 
-	if match_idx == -1 {
+	if len(set) == 0 {
 		return
 	}
 
-	// Mark both as won by current player. Advise both players of removal.
-	win := p * 11
-	board[match_idx].disp = win
-	board[flip_idx].disp = win
-	rm_str := fmt.Sprintf("R%03d%03d", flip_idx, match_idx)
-	game.P1.board <- rm_str
-	game.P2.board <- rm_str
+	// Mark the set as won by current player. Advise all players of removal.
+	// The flipped tile is listed first.
+	set = append([]int{flip_idx}, set...)
+	win := p * WON_BY
+	for _, idx := range set {
+		board[idx].disp = win
+	}
+	rm_str := tilesMsg("R", set, p)
+	sendBoards(game, p, rm_str, rm_str)
 
 	return
 }
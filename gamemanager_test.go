@@ -0,0 +1,279 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A game of bots whose board channels hold every message of a test, on a
+// board dealt with these values
+func testGame(seats, teams, setSize int, values []int) (*game_t, tilearray_t) {
+	VerboseGlobal = false
+	clock := &virtualClock_t{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	game := &game_t{Status: GAME_RUNNING, Tmax: len(values), Seats: seats, Teams: teams, SetSize: setSize, clock: clock}
+	for p := 1; p <= seats; p++ {
+		game.Players = append(game.Players, player_t{Num: p, IsBot: true, Team: seatTeam(p, teams),
+			board: make(chan string, 100)})
+	}
+	game.clearScores()
+
+	board := make(tilearray_t, len(values))
+	for t, val := range values {
+		board[t].val = val
+	}
+	game.replay = newReplay(game, board, 1)
+	return game, board
+}
+
+func disps(board tilearray_t) []int {
+	d := []int{}
+	for _, tile := range board {
+		d = append(d, tile.disp)
+	}
+	return d
+}
+
+// ---------------------------------------------------------------------------
+// Flips, each {player, tile}, and the board and guzumps they leave
+// ---------------------------------------------------------------------------
+
+func TestFlipTile(t *testing.T) {
+	tests := []struct {
+		name    string
+		seats   int
+		teams   int
+		setSize int
+		values  []int
+		flips   [][2]int
+		disp    []int
+		guzumps []int
+	}{
+		{"pair", 2, 0, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {1, 1}},
+			[]int{11, 11, 0, 0}, []int{0, 0, 0}},
+		{"mismatch hidden by next flip", 2, 0, 2, []int{1, 2, 1, 2},
+			[][2]int{{1, 0}, {1, 1}, {1, 2}},
+			[]int{0, 0, 1, 0}, []int{0, 0, 0}},
+		{"face-up tile not flipped again", 2, 0, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {2, 0}},
+			[]int{1, 0, 0, 0}, []int{0, 0, 0}},
+		{"guzump", 2, 0, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {2, 1}},
+			[]int{22, 22, 0, 0}, []int{0, 0, 1}},
+		{"no guzump with own tiles up", 2, 0, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {2, 2}, {2, 1}},
+			[]int{1, 2, 2, 0}, []int{0, 0, 0}},
+		{"teammate's set", 4, 2, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {3, 1}},
+			[]int{33, 33, 0, 0}, []int{0, 0, 0, 0, 0}},
+		{"opponent team's set", 4, 2, 2, []int{1, 1, 2, 2},
+			[][2]int{{1, 0}, {2, 1}},
+			[]int{22, 22, 0, 0}, []int{0, 0, 1, 0, 0}},
+		{"triple", 2, 0, 3, []int{1, 1, 1, 2, 2, 2},
+			[][2]int{{1, 0}, {1, 1}, {1, 2}},
+			[]int{11, 11, 11, 0, 0, 0}, []int{0, 0, 0}},
+		{"two of a triple", 2, 0, 3, []int{1, 1, 1, 2, 2, 2},
+			[][2]int{{1, 0}, {1, 1}},
+			[]int{1, 1, 0, 0, 0, 0}, []int{0, 0, 0}},
+		{"triple guzump", 2, 0, 3, []int{1, 1, 1, 2, 2, 2},
+			[][2]int{{1, 0}, {1, 1}, {2, 2}},
+			[]int{22, 22, 22, 0, 0, 0}, []int{0, 0, 1}},
+		{"quad", 3, 0, 4, []int{1, 2, 1, 2, 1, 2, 1, 2},
+			[][2]int{{1, 0}, {1, 2}, {1, 4}, {1, 6}},
+			[]int{11, 0, 11, 0, 11, 0, 11, 0}, []int{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, board := testGame(tt.seats, tt.teams, tt.setSize, tt.values)
+			for _, flip := range tt.flips {
+				flipTile(game, flip[0], flip[1], board)
+			}
+			if got := disps(board); !reflect.DeepEqual(got, tt.disp) {
+				t.Errorf("board %v, want %v", got, tt.disp)
+			}
+			if !reflect.DeepEqual(game.guzumps, tt.guzumps) {
+				t.Errorf("guzumps %v, want %v", game.guzumps, tt.guzumps)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Forfeits: the game goes on while two sides remain, and the last side left
+// wins every tile still on the board
+// ---------------------------------------------------------------------------
+
+func TestForfeitGame(t *testing.T) {
+	tests := []struct {
+		name    string
+		seats   int
+		teams   int
+		flips   [][2]int
+		forfeit []int  // In order
+		over    []bool // After each forfeit
+		disp    []int
+		winner  int
+	}{
+		{"one of two", 2, 0, [][2]int{{1, 0}, {1, 1}, {2, 2}},
+			[]int{1}, []bool{true},
+			[]int{11, 11, 22, 22, 22, 22}, 2},
+		{"one of three", 3, 0, [][2]int{{1, 0}, {1, 1}, {2, 2}},
+			[]int{2}, []bool{false},
+			[]int{11, 11, 0, 0, 0, 0}, 1},
+		{"two of three", 3, 0, [][2]int{{1, 0}, {1, 1}, {2, 2}},
+			[]int{2, 1}, []bool{false, true},
+			[]int{11, 11, 33, 33, 33, 33}, 3},
+		{"one of a team", 4, 2, [][2]int{{2, 0}},
+			[]int{2}, []bool{false},
+			[]int{0, 0, 0, 0, 0, 0}, 0},
+		{"a whole team", 4, 2, [][2]int{{1, 0}, {1, 1}},
+			[]int{2, 4}, []bool{false, true},
+			[]int{11, 11, 11, 11, 11, 11}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, board := testGame(tt.seats, tt.teams, 2, []int{1, 1, 2, 2, 3, 3})
+			for _, flip := range tt.flips {
+				flipTile(game, flip[0], flip[1], board)
+			}
+			for f, p := range tt.forfeit {
+				if over := forfeitGame(game, p, board); over != tt.over[f] {
+					t.Errorf("forfeit by %d: over %v, want %v", p, over, tt.over[f])
+				}
+			}
+			if got := disps(board); !reflect.DeepEqual(got, tt.disp) {
+				t.Errorf("board %v, want %v", got, tt.disp)
+			}
+			if winner := game.winner(board); winner != tt.winner {
+				t.Errorf("winner %d, want %d", winner, tt.winner)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// The winning side: the most sets, unless tied or out
+// ---------------------------------------------------------------------------
+
+func TestGameWinner(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []int
+		out    []bool
+		winner int
+	}{
+		{"most sets", []int{0, 3, 1}, nil, 1},
+		{"tied", []int{0, 2, 2}, nil, 0},
+		{"tied for the lead", []int{0, 1, 3, 3}, nil, 0},
+		{"tied below the lead", []int{0, 1, 1, 3}, nil, 3},
+		{"no sets", []int{0, 0, 0}, nil, 0},
+		{"leader out", []int{0, 3, 1}, []bool{false, true, false}, 2},
+		{"tie broken by a forfeit", []int{0, 2, 2}, []bool{false, false, true}, 1},
+		{"all out", []int{0, 2, 1}, []bool{false, true, true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if winner := gameWinner(tt.scores, tt.out); winner != tt.winner {
+				t.Errorf("winner %d, want %d", winner, tt.winner)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Team sides add up their players' sets
+// ---------------------------------------------------------------------------
+
+func TestTeamWinner(t *testing.T) {
+	tests := []struct {
+		name   string
+		flips  [][2]int
+		winner int
+	}{
+		{"tied teams", [][2]int{{1, 0}, {1, 1}, {2, 2}, {2, 3}}, 0},
+		{"team 1 ahead", [][2]int{{1, 0}, {1, 1}, {2, 2}, {2, 3}, {3, 4}, {3, 5}}, 1},
+		{"team 2 ahead by a guzump", [][2]int{{1, 0}, {4, 1}}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, board := testGame(4, 2, 2, []int{1, 1, 2, 2, 3, 3})
+			for _, flip := range tt.flips {
+				flipTile(game, flip[0], flip[1], board)
+			}
+			if winner := game.winner(board); winner != tt.winner {
+				t.Errorf("winner %d, want %d", winner, tt.winner)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Turns: a mismatch stays on show, with nobody to flip, until its tiles are
+// hidden and the turn passes; a set keeps the turn
+// ---------------------------------------------------------------------------
+
+func TestTurnMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		hideAfter time.Duration
+		flips     []int
+		turn      int
+	}{
+		{"set keeps the turn", 0, []int{0, 1}, 1},
+		{"mismatch on show", 0, []int{0, 2}, 2},
+		{"mismatch hidden after the usual delay", 3 * time.Second, []int{0, 2}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, board := testGame(2, 0, 2, []int{1, 1, 2, 2})
+			game.TurnBased = true
+			game.hideAfter = tt.hideAfter
+			clock := game.clock.(*virtualClock_t)
+			startTurn(game, 1)
+			for _, idx := range tt.flips {
+				flipTile(game, 1, idx, board)
+				revealMismatch(game, 1, board)
+			}
+			if tt.turn == 1 {
+				if !game.hasTurn(1) || !game.nextHide().IsZero() {
+					t.Fatal("player 1 lost the turn with a set")
+				}
+				return
+			}
+
+			if game.hasTurn(1) || game.hasTurn(2) {
+				t.Fatal("a player may flip while the mismatch is on show")
+			}
+			if autoHide(game, board) {
+				t.Fatal("turn passed before the tiles were hidden")
+			}
+			if got := disps(board); !reflect.DeepEqual(got, []int{1, 0, 1, 0}) {
+				t.Fatalf("board %v before the tiles are hidden", got)
+			}
+			wait := tt.hideAfter
+			if wait == 0 {
+				wait = TURN_REVEAL
+			}
+			if at := game.nextHide(); !at.Equal(clock.Now().Add(wait)) {
+				t.Fatalf("tiles hidden at %v, want %v after the flip", at, wait)
+			}
+
+			clock.advanceTo(game.nextHide())
+			if !autoHide(game, board) {
+				t.Fatal("turn not passed when the tiles were hidden")
+			}
+			if got := disps(board); !reflect.DeepEqual(got, []int{0, 0, 0, 0}) {
+				t.Errorf("board %v after the tiles are hidden", got)
+			}
+			if !game.hasTurn(tt.turn) {
+				t.Errorf("turn %d, want %d", game.turn, tt.turn)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// ---------------------------------------------------------------------------
+// Match history.
+//
+// Every game played on the server is recorded when it finishes, in an
+// SQLite database: the board and rules, the seed that dealt it, how long it
+// took and who won, and for each seat the player (account, guest or bot
+// profile), their team, the tiles and sets they won, their flips and fouls,
+// and whether they forfeited. A rated game also records each player's
+// rating before and after (see ratings.go). Simulated games are not recorded.
+//
+// The schema is built up by the migrations in historyMigrations, applied in
+// order when the database is opened. The database's user_version is the
+// number already applied. A change to the schema is a new migration at the
+// end of the list; a migration once released is never edited.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const HISTORY_FILE = "history.db"
+
+// The database of finished games, or "" to keep none
+var HistoryFile = HISTORY_FILE
+
+var HistoryDB *sql.DB
+
+// Migration n (from 1) takes the schema from version n-1 to version n
+var historyMigrations = []string{
+	// 1: Games, and the players in each seat
+	`CREATE TABLE games (
+		id          INTEGER PRIMARY KEY,
+		started     TEXT    NOT NULL, -- RFC 3339, UTC
+		duration_ms INTEGER NOT NULL,
+		slot        INTEGER NOT NULL,
+		game_num    INTEGER NOT NULL, -- Of the games played in the slot by these players
+		tiles       INTEGER NOT NULL,
+		set_size    INTEGER NOT NULL,
+		seats       INTEGER NOT NULL,
+		teams       INTEGER NOT NULL, -- 0 if every player is for themselves
+		turn_based  INTEGER NOT NULL,
+		private     INTEGER NOT NULL,
+		seed        INTEGER NOT NULL,
+		winner      INTEGER NOT NULL, -- Seat, or team in a team game; 0 if tied
+		flips       INTEGER NOT NULL
+	);
+	CREATE TABLE game_players (
+		game_id     INTEGER NOT NULL REFERENCES games(id),
+		seat        INTEGER NOT NULL,
+		name        TEXT    NOT NULL,
+		account     TEXT,             -- NULL for a guest or bot
+		bot_profile TEXT,             -- NULL for a human
+		team        INTEGER NOT NULL,
+		tiles_won   INTEGER NOT NULL,
+		sets_won    INTEGER NOT NULL,
+		flips       INTEGER NOT NULL,
+		fouls       INTEGER NOT NULL,
+		forfeited   INTEGER NOT NULL,
+		PRIMARY KEY (game_id, seat)
+	);
+	CREATE INDEX game_players_account ON game_players(account);
+	CREATE INDEX game_players_bot ON game_players(bot_profile);
+	CREATE INDEX games_started ON games(started);`,
+
+	// 2: Ratings (see ratings.go), and the change to them in each rated game
+	`CREATE TABLE ratings (
+		kind       TEXT    NOT NULL, -- 'account' or 'bot'
+		name       TEXT    NOT NULL, -- Account, or bot profile
+		tiles      INTEGER NOT NULL,
+		turn_based INTEGER NOT NULL,
+		rating     REAL    NOT NULL,
+		games      INTEGER NOT NULL,
+		wins       INTEGER NOT NULL,
+		losses     INTEGER NOT NULL,
+		ties       INTEGER NOT NULL,
+		updated    TEXT    NOT NULL, -- RFC 3339, UTC
+		PRIMARY KEY (kind, name, tiles, turn_based)
+	);
+	CREATE INDEX ratings_board ON ratings(tiles, turn_based, rating);
+	ALTER TABLE games ADD COLUMN rated INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE game_players ADD COLUMN rating_before REAL;
+	ALTER TABLE game_players ADD COLUMN rating_after REAL;`,
+
+	// 3: Ratings by set size too. Ratings until now mixed set sizes, so they
+	// are dropped; the history keeps each game's ratings before and after.
+	`DROP TABLE ratings;
+	CREATE TABLE ratings (
+		kind       TEXT    NOT NULL, -- 'account' or 'bot'
+		name       TEXT    NOT NULL, -- Account, or bot profile
+		tiles      INTEGER NOT NULL,
+		set_size   INTEGER NOT NULL,
+		turn_based INTEGER NOT NULL,
+		rating     REAL    NOT NULL,
+		games      INTEGER NOT NULL,
+		wins       INTEGER NOT NULL,
+		losses     INTEGER NOT NULL,
+		ties       INTEGER NOT NULL,
+		updated    TEXT    NOT NULL, -- RFC 3339, UTC
+		PRIMARY KEY (kind, name, tiles, set_size, turn_based)
+	);
+	CREATE INDEX ratings_board ON ratings(tiles, set_size, turn_based, rating);`,
+}
+
+// ---------------------------------------------------------------------------
+// Open the history database at startup, bringing its schema up to date
+// ---------------------------------------------------------------------------
+
+func openHistory(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// One writer at a time, so that no game finds the database locked
+	db.SetMaxOpenConns(1)
+
+	err = migrateHistory(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateHistory(db *sql.DB) error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(historyMigrations) {
+		return fmt.Errorf("history schema version %d is newer than this server (%d)", version, len(historyMigrations))
+	}
+
+	for v := version; v < len(historyMigrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(historyMigrations[v])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", v+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("history migration %d: %w", v+1, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		log.Println("History schema migrated to version", v+1)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Record a finished game. Failure is logged but does not stop play.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) record(board tilearray_t, winner int) {
+	if HistoryDB == nil {
+		return
+	}
+	err := game.insertHistory(board, winner)
+	if err != nil {
+		log.Println("Game not recorded:", err)
+	}
+}
+
+func (game *game_t) insertHistory(board tilearray_t, winner int) error {
+	r := game.replay
+	sets := gameScores(board, len(game.Players), game.SetSize)
+	flips := 0
+	for _, f := range game.flips {
+		flips += f
+	}
+	rated := game.rated()
+
+	tx, err := HistoryDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO games (started, duration_ms, slot, game_num, tiles, set_size, seats,
+			teams, turn_based, private, seed, winner, flips, rated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Started.UTC().Format(time.RFC3339Nano), game.clock.Now().Sub(r.Started).Milliseconds(), game.idx,
+		game.GameCounter, game.Tmax, game.SetSize, len(game.Players), game.Teams, game.TurnBased, game.Private,
+		r.Seed, winner, flips, rated)
+	if err != nil {
+		return err
+	}
+	gameID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	var before, after []float64
+	if rated {
+		before, after, err = game.rate(tx, winner)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, player := range game.Players {
+		var account, botProfile sql.NullString
+		if player.account != "" {
+			account = sql.NullString{String: player.account, Valid: true}
+		}
+		if player.bot != nil {
+			botProfile = sql.NullString{String: player.bot.Name, Valid: true}
+		}
+		p := player.Num
+		var ratingBefore, ratingAfter sql.NullFloat64
+		if rated {
+			ratingBefore = sql.NullFloat64{Float64: before[p], Valid: true}
+			ratingAfter = sql.NullFloat64{Float64: after[p], Valid: true}
+		}
+		_, err = tx.Exec(`INSERT INTO game_players (game_id, seat, name, account, bot_profile, team,
+				tiles_won, sets_won, flips, fouls, forfeited, rating_before, rating_after)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			gameID, p, player.Name, account, botProfile, player.Team,
+			sets[p]*game.SetSize, sets[p], game.flips[p], game.fouls[p], game.out[p], ratingBefore, ratingAfter)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
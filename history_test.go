@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// Migrating the history database: an empty one is brought up to date, one
+// already up to date is left alone, and one from a newer server is refused
+// ---------------------------------------------------------------------------
+
+func TestMigrateHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int // Schema version before the server opens it
+		ok      bool
+	}{
+		{"empty", 0, true},
+		{"up to date", len(historyMigrations), true},
+		{"newer", len(historyMigrations) + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.db")
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if tt.version == len(historyMigrations) {
+				err = migrateHistory(db)
+			} else if tt.version > 0 {
+				_, err = db.Exec("PRAGMA user_version = " + strconv.Itoa(tt.version))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = migrateHistory(db)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			var version int
+			err = db.QueryRow("PRAGMA user_version").Scan(&version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != len(historyMigrations) {
+				t.Errorf("version %d, want %d", version, len(historyMigrations))
+			}
+			for _, table := range []string{"games", "game_players", "ratings"} {
+				var n int
+				err = db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n)
+				if err != nil {
+					t.Errorf("table %s: %v", table, err)
+				}
+			}
+		})
+	}
+}
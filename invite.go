@@ -0,0 +1,87 @@
+// ---------------------------------------------------------------------------
+// Private games.
+//
+// A private game is made with a short invite code, and optionally a
+// password. The lobby sees only that its slot is taken: not its players, nor
+// a way to join or watch it. Joining (or spectating) a private game takes
+// its code, and the password if it has one. The player who makes the game is
+// sent the code, to pass on as it is or in a link to the game page.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"math/big"
+)
+
+const INVITE_LEN = 6
+
+// No 0/O or 1/I, so a code read aloud or copied by hand still works
+const INVITE_CHARS = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ---------------------------------------------------------------------------
+// A new invite code, unlike that of any game in the table. Called with
+// GamesMu held.
+// ---------------------------------------------------------------------------
+
+func newInviteCode() string {
+	for {
+		code := make([]byte, INVITE_LEN)
+		for c := range code {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(INVITE_CHARS))))
+			if err != nil {
+				panic(err)
+			}
+			code[c] = INVITE_CHARS[n.Int64()]
+		}
+		if findInvite(string(code)) < 0 {
+			return string(code)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Returns: the slot of the private game with this invite code, or -1
+// ---------------------------------------------------------------------------
+
+func inviteSlot(code string) int {
+	GamesMu.Lock()
+	defer GamesMu.Unlock()
+	return findInvite(code)
+}
+
+// Called with GamesMu held
+func findInvite(code string) int {
+	for g := range Games {
+		if Games[g].Private && Games[g].Status != GAME_EMPTY && Games[g].invite == code {
+			return g
+		}
+	}
+	return -1
+}
+
+// ---------------------------------------------------------------------------
+// Returns: true if the game may be joined or watched with this invite code
+// and password. A public game needs neither.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) admits(code, password string) bool {
+	if !game.Private {
+		return true
+	}
+	return code == game.invite &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(game.password)) == 1
+}
+
+// ---------------------------------------------------------------------------
+// The game as the lobby sees it: of a private game, only that it is there
+// ---------------------------------------------------------------------------
+
+func (game *game_t) lobbyView() *game_t {
+	if !game.Private {
+		return game
+	}
+	return &game_t{Status: game.Status, Private: true}
+}
@@ -0,0 +1,54 @@
+// ---------------------------------------------------------------------------
+// The leaderboard page: ratings from /leaderboard.json, filtered by board
+// size, set size and mode, with or without bots
+// ---------------------------------------------------------------------------
+
+document.addEventListener("DOMContentLoaded", function () {
+  for (let id of ["leaderTiles", "leaderSetSize", "leaderMode", "leaderBots"]) {
+    document.getElementById(id).onchange = showLeaders
+  }
+  showLeaders()
+})
+
+function showLeaders() {
+  let query = new URLSearchParams()
+  let tiles = document.getElementById("leaderTiles").value
+  let setSize = document.getElementById("leaderSetSize").value
+  let mode = document.getElementById("leaderMode").value
+  if (tiles) {
+    query.set("tiles", tiles)
+  }
+  if (setSize) {
+    query.set("setsize", setSize)
+  }
+  if (mode) {
+    query.set("mode", mode)
+  }
+  if (!document.getElementById("leaderBots").checked) {
+    query.set("bots", "0")
+  }
+
+  fetch("/leaderboard.json?" + query).then(function (resp) {
+    if (!resp.ok) {
+      resp.text().then(function (text) {
+        document.getElementById("status").textContent = text
+      })
+      return
+    }
+    resp.json().then(function (leaders) {
+      let body = document.getElementById("leaders")
+      body.replaceChildren()
+      for (let l of leaders) {
+        let row = document.createElement("tr")
+        let name = l.Bot ? l.Name + " (bot)" : l.Name
+        for (let cell of [l.Rank, name, l.Tiles, l.SetSize, l.Mode, l.Rating, l.Games, l.Wins, l.Losses, l.Ties]) {
+          let td = document.createElement("td")
+          td.textContent = cell
+          row.appendChild(td)
+        }
+        body.appendChild(row)
+      }
+      document.getElementById("status").textContent = leaders.length ? "" : "No rated games yet"
+    })
+  })
+}
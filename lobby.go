@@ -0,0 +1,64 @@
+// ---------------------------------------------------------------------------
+// The lobby.
+//
+// A client that has connected but not yet started, joined or spectated a
+// game is in the lobby. It is sent the table of games in progress when it
+// arrives, then each change to the table as it happens: a game created, a
+// player joining (or leaving before it starts), a game finished, and a slot
+// freed once everybody in its game has left. Lobby messages are queued like
+// any others, ready made as Json.
+// ---------------------------------------------------------------------------
+
+package main
+
+import "sync"
+
+// Changes to a game in the table
+const (
+	LOBBY_CREATED  = "Created"
+	LOBBY_JOINED   = "Joined"
+	LOBBY_LEFT     = "Left"
+	LOBBY_FINISHED = "Finished"
+	LOBBY_FREED    = "Freed"
+)
+
+// Guards the lobby. Taken after GamesMu, never before.
+var LobbyMu sync.Mutex
+
+var Lobby = make(map[*outQueue_t]bool)
+
+// ---------------------------------------------------------------------------
+// Enter and leave the lobby. A client entering is sent the table as it is,
+// and will be sent every change from then on.
+// ---------------------------------------------------------------------------
+
+func joinLobby(queue *outQueue_t) {
+	GamesMu.Lock()
+	defer GamesMu.Unlock()
+	LobbyMu.Lock()
+	defer LobbyMu.Unlock()
+
+	Lobby[queue] = true
+	queue.push(gamesInProgressMsg())
+}
+
+func leaveLobby(queue *outQueue_t) {
+	LobbyMu.Lock()
+	defer LobbyMu.Unlock()
+	delete(Lobby, queue)
+}
+
+// ---------------------------------------------------------------------------
+// Tell every client in the lobby of a change to this game. Called with
+// GamesMu held, so the table does not change under the message.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) lobbyUpdate(event string) {
+	msg := gameUpdateMsg(game, event)
+
+	LobbyMu.Lock()
+	defer LobbyMu.Unlock()
+	for queue := range Lobby {
+		queue.push(msg)
+	}
+}
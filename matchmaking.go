@@ -0,0 +1,211 @@
+// ---------------------------------------------------------------------------
+// Matchmaking.
+//
+// Rather than pick a slot in the lobby, a player may ask to be found a
+// match: a two-player game on a board of the size, set size and mode (racing
+// or turns) they ask for. Players are queued by what they ask for, and
+// paired with the waiting player closest to them in rating for that game
+// (see ratings.go; a guest counts as a new player). At first only a close
+// rating will do; the longer a player has waited, the wider the gap they
+// accept. A player still unmatched after MatchWait plays a bot instead.
+//
+// Both players of a match are seated at once, so nobody in the lobby can
+// take the seat first. Whoever makes the match runs the game. A player who
+// closes the socket while waiting leaves the queue, which is also how a
+// player cancels.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const MATCH_RATING = 1500       // Rating of a player not yet rated
+const MATCH_SPREAD = 100        // Rating gap accepted at once
+const MATCH_SPREAD_PER_SEC = 20 // ... widening by this each second waited
+const MATCH_RETRY = 1 * time.Second
+
+// How long a player waits for an opponent before playing a bot, and which
+// (which also takes the seats of a waiting game that nobody took)
+var MatchWait = 30 * time.Second
+var MatchBot = 1
+
+type matchKey_t struct {
+	tMax      int
+	setSize   int
+	turnBased bool
+}
+
+type matchReq_t struct {
+	key     matchKey_t
+	rating  int
+	human   player_t
+	since   time.Time
+	queued  bool
+	matched chan match_t // The game, once another player makes the match
+}
+
+type match_t struct {
+	game *game_t
+	p    int
+}
+
+// Guards the match queue
+var MatchMu sync.Mutex
+
+var MatchQueue []*matchReq_t
+
+// ---------------------------------------------------------------------------
+// Find the human a match, waiting for an opponent if need be, unless they
+// leave first (gone closes).
+//
+// Returns: as joinGame does; whether every seat is filled is true only for
+// the player who runs the game
+// ---------------------------------------------------------------------------
+
+func findMatch(ng newGame_t, human player_t, gone chan bool) (*game_t, int, bool, bool) {
+	key := matchKey_t{ng.Tmax, ng.SetSize, ng.TurnBased}
+	req := &matchReq_t{key, matchRating(human, key), human, time.Now(), true, make(chan match_t, 1)}
+
+	MatchMu.Lock()
+	MatchQueue = append(MatchQueue, req)
+	MatchMu.Unlock()
+
+	retry := time.NewTicker(MATCH_RETRY)
+	defer retry.Stop()
+	giveUp := time.After(MatchWait)
+
+	for {
+		// Try for an opponent on arrival, then every retry
+		MatchMu.Lock()
+		if req.queued {
+			if opp := req.opponent(); opp != nil {
+				opp.unqueue()
+				req.unqueue()
+				MatchMu.Unlock()
+				return startMatch(opp, req)
+			}
+		}
+		MatchMu.Unlock()
+
+		select {
+		case m := <-req.matched:
+			return m.game, m.p, false, m.game != nil
+		case <-retry.C:
+		case <-giveUp:
+			MatchMu.Lock()
+			queued := req.queued
+			req.unqueue()
+			MatchMu.Unlock()
+			if queued {
+				return startBotMatch(req)
+			}
+			giveUp = nil // Matched meanwhile: the game is on its way
+		case <-gone:
+			MatchMu.Lock()
+			queued := req.queued
+			req.unqueue()
+			MatchMu.Unlock()
+			if queued {
+				return nil, 0, false, false
+			}
+			gone = nil // Matched meanwhile: the game will see them leave
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// The waiting player who best matches this one: asking for the same game,
+// and of the closest rating within the gap the longer waiter of the two
+// accepts. Called with MatchMu held.
+// ---------------------------------------------------------------------------
+
+func (req *matchReq_t) opponent() *matchReq_t {
+	var best *matchReq_t
+	bestGap := 0
+	for _, opp := range MatchQueue {
+		if opp == req || opp.key != req.key {
+			continue
+		}
+		gap := opp.rating - req.rating
+		if gap < 0 {
+			gap = -gap
+		}
+		waited := time.Since(opp.since)
+		if mine := time.Since(req.since); mine > waited {
+			waited = mine
+		}
+		if gap > MATCH_SPREAD+MATCH_SPREAD_PER_SEC*int(waited/time.Second) {
+			continue
+		}
+		if best == nil || gap < bestGap {
+			best = opp
+			bestGap = gap
+		}
+	}
+	return best
+}
+
+// Called with MatchMu held
+func (req *matchReq_t) unqueue() {
+	req.queued = false
+	for r, queued := range MatchQueue {
+		if queued == req {
+			MatchQueue = append(MatchQueue[:r], MatchQueue[r+1:]...)
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Seat two matched players, the longer waiter first, and let the waiter know
+// ---------------------------------------------------------------------------
+
+func startMatch(first, second *matchReq_t) (*game_t, int, bool, bool) {
+	ng := newGame_t{Tmax: second.key.tMax, Seats: MIN_SEATS, SetSize: second.key.setSize, TurnBased: second.key.turnBased}
+	game, full, success := newGameAnywhere(ng, first.human, second.human)
+	if !success {
+		first.matched <- match_t{nil, 0}
+		return nil, 0, false, false
+	}
+	first.matched <- match_t{game, 1}
+	return game, 2, full, true
+}
+
+// ---------------------------------------------------------------------------
+// Nobody came: play a bot
+// ---------------------------------------------------------------------------
+
+func startBotMatch(req *matchReq_t) (*game_t, int, bool, bool) {
+	ng := newGame_t{Tmax: req.key.tMax, Seats: MIN_SEATS, SetSize: req.key.setSize, TurnBased: req.key.turnBased,
+		OppBot: defaultBot(), Bots: 1}
+	game, full, success := newGameAnywhere(ng, req.human)
+	return game, 1, full, success
+}
+
+// ---------------------------------------------------------------------------
+// The bot profile for a player nobody matched, or seats nobody took
+// ---------------------------------------------------------------------------
+
+func defaultBot() int {
+	if MatchBot <= 0 || MatchBot >= len(BotProfiles) {
+		return 1
+	}
+	return MatchBot
+}
+
+// ---------------------------------------------------------------------------
+// Set up a new game in the first free slot, as newGame does
+// ---------------------------------------------------------------------------
+
+func newGameAnywhere(ng newGame_t, humans ...player_t) (*game_t, bool, bool) {
+	for g := range Games {
+		ng.Idx = g
+		if game, full, success := newGame(ng, humans...); success {
+			return game, full, true
+		}
+	}
+	return nil, false, false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Choosing an opponent: the same game, the closest rating, and a wider gap
+// the longer either player has waited
+// ---------------------------------------------------------------------------
+
+func TestOpponent(t *testing.T) {
+	key := matchKey_t{tMax: 20, setSize: 2}
+	type waiting struct {
+		key    matchKey_t
+		rating int
+		waited time.Duration
+	}
+
+	tests := []struct {
+		name   string
+		rating int
+		waited time.Duration
+		queue  []waiting
+		want   int // Index in the queue of the opponent, or -1 for none
+	}{
+		{"nobody", 1500, 0, nil, -1},
+		{"same game", 1500, 0, []waiting{{key, 1500, 0}}, 0},
+		{"other set size", 1500, 0, []waiting{{matchKey_t{tMax: 20, setSize: 3}, 1500, 0}}, -1},
+		{"other mode", 1500, 0, []waiting{{matchKey_t{tMax: 20, setSize: 2, turnBased: true}, 1500, 0}}, -1},
+		{"closest", 1500, 0, []waiting{{key, 1580, 0}, {key, 1450, 0}, {key, 1530, 0}}, 2},
+		{"gap too wide", 1500, 0, []waiting{{key, 1650, 0}}, -1},
+		{"opponent waited", 1500, 0, []waiting{{key, 1650, 3 * time.Second}}, 0},
+		{"player waited", 1500, 3 * time.Second, []waiting{{key, 1650, 0}}, 0},
+		{"still too wide", 1500, 3 * time.Second, []waiting{{key, 1700, 0}}, -1},
+	}
+
+	saved := MatchQueue
+	defer func() { MatchQueue = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			req := &matchReq_t{key: key, rating: tt.rating, since: now.Add(-tt.waited)}
+			MatchQueue = []*matchReq_t{req}
+			for _, w := range tt.queue {
+				MatchQueue = append(MatchQueue, &matchReq_t{key: w.key, rating: w.rating, since: now.Add(-w.waited)})
+			}
+
+			var want *matchReq_t
+			if tt.want >= 0 {
+				want = MatchQueue[tt.want+1]
+			}
+			if opp := req.opponent(); opp != want {
+				t.Errorf("opponent %+v, want %+v", opp, want)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A player who leaves while waiting for a match leaves the queue
+// ---------------------------------------------------------------------------
+
+func TestFindMatchGone(t *testing.T) {
+	saved := MatchQueue
+	MatchQueue = nil
+	defer func() { MatchQueue = saved }()
+
+	gone := make(chan bool)
+	found := make(chan bool)
+	go func() {
+		game, _, _, success := findMatch(newGame_t{Tmax: 20, SetSize: 2}, player_t{Name: "Ann"}, gone)
+		found <- game != nil || success
+	}()
+
+	for queued := 0; queued == 0; {
+		time.Sleep(time.Millisecond)
+		MatchMu.Lock()
+		queued = len(MatchQueue)
+		MatchMu.Unlock()
+	}
+	close(gone)
+
+	select {
+	case matched := <-found:
+		if matched {
+			t.Error("matched after leaving")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after leaving")
+	}
+	MatchMu.Lock()
+	defer MatchMu.Unlock()
+	if len(MatchQueue) != 0 {
+		t.Errorf("%d still queued, want none", len(MatchQueue))
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"time"
 
@@ -11,85 +13,370 @@ import (
 
 // ---------------------------------------------------------------------------
 // A memory-game bot
-//  p = player 1 or 2
-//  tMax = number of tiles in tile arrays
-//  mem = a slice/array of remembered tile IDs indexed by tile number
-//  state = a slice/array of tile states
-//          (0 = face-down, 1 = taken, 2 = face-up-you, 3 = face-up-opp)
-//  known = a slice/array of tile numbers, indexed by tile ID
+//  p = player 1 to MAX_SEATS
+//  rules = the game's tiles, seats, teams and set size
+//  botmem = the bot's own memory of the board, indexed by tile number
+//          disp: 0 = face-down, 1 = taken, 2 = face-up-you, 3 = face-up-opp
+//                (any opponent), 4 = face-up-team (a teammate)
+//          val:  remembered tile value, or NOVAL if not known
 //  board = chan for game board to advise state-change on board
 //  move =  chan to advise game board of next move (flip, hide)
+//
+// A bot only ever sees the messages a human player would see. It is never
+// given the server's board, so its choices depend only on the messages it
+// has received (and its own random numbers).
 // ---------------------------------------------------------------------------
 
 const REMOVED int = 1
 const FACEUP_ME int = 2
 const FACEUP_OPP int = 3
+const FACEUP_TEAM int = 4
 
-func memBot(p player_t, tMax int, verbose bool, board tilearray_t) {
-	var botmem = make(tilearray_t, tMax)
+// Kinds of bot action. Each has its own reaction time in a bot profile.
+const ACT_NONE int = -1 // No face-down tiles remain
+const ACT_FIRST int = 0
+const ACT_SECOND int = 1
+const ACT_GUZUMP int = 2
 
-	if p.slowPc < 10 || p.slowPc > 100 {
-		p.slowPc = 100
-	}
-	if p.memPc < 20 || p.memPc > 100 {
-		p.memPc = 100
-	}
-	if verbose {
-		log.Printf("Bot %d started - Slow%% %d - Memory%% %d\n", p.Num, p.slowPc, p.memPc)
-		log.Printf("Capacity is %d\n", cap(board))
-	}
+var ACT_NAMES = []string{"first", "second", "guzump"}
 
-	for {
-		// Read board updates, and update in the bot's memory of the board
-	channel_read_loop:
-		for {
-			select {
-			case b := <-p.board:
-				if b[0] == 'F' { // (F)lipped by this bot
-					botmem[:].botRevealTile(p.Num, b, verbose)
-				} else if b[0] == 'O' { // (O)pponent flipped tile
-					botmem[:].botRevealTile(p.Num, b, verbose)
-				} else if b[0] == 'H' { // (H)ide unmatched tiles
-					botmem[:].botHideTiles(p.Num, b, verbose, p.memPc)
-				} else if b[0] == 'R' { // (R)emove matched tiles
-					botmem[:].botRemoveTiles(p.Num, b, verbose)
-				}
-			default:
-				break channel_read_loop
-			}
-		}
+const BOT_MIN_REACT_MS = 80               // Nobody is faster than this
+const BOT_AWAIT_TIMEOUT = 2 * time.Second // Give up waiting for own flip
 
-		// For debug purposes, display bot's view of tiles
-		if VerboseGlobal {
-			botmem[:].dispBotmem(p)
-		}
+// ---------------------------------------------------------------------------
+// Bot profiles, selected by the OppBot index of a NewGame request. Index 0 is
+// reserved for "no bot". A profile with a command line is run as a child
+// process by procBot; otherwise it is a memBot with the given abilities.
+//
+// Reaction times are normally distributed, with a mean and standard
+// deviation in milliseconds for each kind of action: first flip, second
+// flip and guzump attempt. All are scaled by slowPc (100 = as given).
+//
+// Memory: memPc is the chance of noticing each tile as it is hidden. Recall
+// of a remembered tile then halves every halfLifeMs milliseconds and every
+// flipHalfLife flips seen since (zero means no decay). At most capacity±capSd
+// tiles are held (zero means no limit); beyond that the oldest is forgotten.
+//
+// Strategy is STRAT_GREEDY (botChoose) or STRAT_EXPERT (botChooseExpert).
+// An adaptive profile changes slowPc and memPc as it plays (botadapt.go).
+// ---------------------------------------------------------------------------
+
+type reaction_t struct {
+	meanMs int
+	sdMs   int
+}
+
+type memory_t struct {
+	capacity     int
+	capSd        int
+	halfLifeMs   int
+	flipHalfLife int
+}
+
+type botProfile_t struct {
+	Name     string
+	slowPc   int
+	memPc    int
+	mem      memory_t
+	react    [3]reaction_t
+	strategy int
+	adapt    *adapt_t
+	cmd      string
+}
+
+var BotProfiles = []botProfile_t{
+	{Name: ""},
+	{Name: "MEMBOT", slowPc: 100, memPc: 99, mem: memory_t{7, 2, 60000, 40},
+		react: [3]reaction_t{{750, 200}, {600, 150}, {450, 120}}},
+	{Name: "EXPERT", slowPc: 100, memPc: 100, mem: memory_t{9, 1, 120000, 80},
+		react: [3]reaction_t{{550, 120}, {450, 100}, {350, 80}}, strategy: STRAT_EXPERT},
+	{Name: "ADAPTIVE", slowPc: 100, memPc: 95, mem: memory_t{7, 2, 60000, 40},
+		react: [3]reaction_t{{750, 200}, {600, 150}, {450, 120}},
+		adapt: &adapt_t{targetPc: 50, window: 5, slowStepPc: 15, memStepPc: 5,
+			minSlowPc: 60, maxSlowPc: 250, minMemPc: 40, maxMemPc: 100,
+			inGame: true, inGameStepPc: 10}},
+}
+
+// A remembered tile, with when it was last seen (time and flip count), and
+// when its recall was last checked.
+type botTile_t struct {
+	disp      int
+	val       int
+	upBy      int // Player who has it face up
+	seenAt    time.Time
+	seenFlip  int
+	checkAt   time.Time
+	checkFlip int
+}
+type botmem_t []botTile_t
 
-		// Choose and communicate a move
-		tile_idx, noMove := botChoose(p.Num, botmem[:])
+// ---------------------------------------------------------------------------
+// The bot is driven by events. It wakes on every board update, re-evaluates
+// its next move, and flips when its reaction time for that move has passed.
+// If an update changes the kind of move (e.g. the opponent's flip offers a
+// guzump) the bot starts reacting afresh from that moment.
+//
+// The bot's state lives in memBot_t, and all its times come from a clock, so
+// that the same bot can be run in real time by memBot or in virtual time by
+// the simulator (sim.go).
+// ---------------------------------------------------------------------------
 
-		if noMove {
-			p.move <- "N"
+type memBot_t struct {
+	p       player_t
+	prof    botProfile_t
+	botmem  botmem_t
+	rng     *rand.Rand
+	clock   clock_t
+	verbose bool
+
+	capacity int // Tiles the bot can hold in memory this game
+	flipCnt  int // Flips seen this game, by any player
+
+	opp         oppModel_t // Opponent's measured reaction times
+	lastEventAt time.Time
+
+	baseSlowPc int
+	pairs      [MAX_SEATS + 1]int // Sets won this game, by player
+	rules      rules_t
+	turn       int // Player whose turn it is, in a turn-based game
+
+	plan_idx  int           // Tile the bot intends to flip
+	plan_act  int           // ... and why
+	plan      botDecision_t // ... explained
+	await_idx int           // Tile flipped, awaiting the board's response
+	wakeAt    time.Time     // When to flip (or give up awaiting)
+}
+
+func memBot(p player_t, rules rules_t, verbose bool, clock clock_t) {
+	bot := newMemBot(p, rules, verbose, clock, rand.Int63())
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		if bot.think() {
+			p.sendMove("N", "")
 			if VerboseGlobal {
 				log.Println("Bot", p.Num, "could not make a move. Bot terminated.")
 			}
-			game_wg.Done()
 			return
 		}
-		if VerboseGlobal {
-			log.Println("Bot", p.Num, "chose tile", tile_idx)
+		timer.Reset(bot.wakeAt.Sub(clock.Now()))
+
+		select {
+		case b := <-p.board:
+			bot.onBoard(b)
+
+		case <-timer.C:
+			tile_idx, dec := bot.wake()
+			if tile_idx < 0 {
+				continue
+			}
+			decJson, _ := json.Marshal(dec)
+			p.sendMove("D", string(decJson))
+
+			p.sendMove("F", fmt.Sprintf("%03d", tile_idx))
 		}
-		flip_str := fmt.Sprintf("F%03d", tile_idx)
-		p.move <- flip_str
+	}
+}
+
+func newMemBot(p player_t, rules rules_t, verbose bool, clock clock_t, seed int64) *memBot_t {
+	bot := memBot_t{p: p, prof: *p.bot, botmem: make(botmem_t, rules.tMax), rules: rules,
+		rng: rand.New(rand.NewSource(seed)), clock: clock, verbose: verbose,
+		plan_idx: -1, plan_act: ACT_NONE, await_idx: -1}
+	prof := &bot.prof
+
+	if prof.slowPc < 10 || prof.slowPc > 1000 {
+		prof.slowPc = 100
+	}
+	if prof.memPc < 20 || prof.memPc > 100 {
+		prof.memPc = 100
+	}
+	bot.baseSlowPc = prof.slowPc
+	bot.capacity = prof.mem.itemCapacity(bot.rng)
+	bot.lastEventAt = clock.Now()
+
+	if verbose {
+		log.Printf("Bot %d started - %s - Memory%% %d - Capacity %d\n", p.Num, prof.Name, prof.memPc, bot.capacity)
+	}
+	return &bot
+}
+
+// ---------------------------------------------------------------------------
+// Re-evaluate the next move, unless waiting on our own flip. A change in the
+// kind of move restarts the bot's reaction time.
+//
+// Returns: true if no face-down tiles remain
+// ---------------------------------------------------------------------------
+
+func (bot *memBot_t) think() bool {
+	if bot.await_idx >= 0 {
+		return false
+	}
+
+	now := bot.clock.Now()
+	bot.botmem[:].botForget(bot.p.Num, bot.prof.mem, bot.capacity, now, bot.flipCnt, bot.rng)
+	if VerboseGlobal {
+		bot.botmem[:].dispBotmem(bot.p)
+	}
+
+	var tile_idx, act int
+	var dec botDecision_t
+	if bot.prof.strategy == STRAT_EXPERT {
+		tile_idx, act = botChooseExpert(bot.p.Num, bot.botmem[:], bot.rules.setSize, bot.rng, &bot.prof, &bot.opp, bot.flipCnt, &dec)
+	} else {
+		tile_idx, act = botChoose(bot.p.Num, bot.botmem[:], bot.rules.setSize, bot.rng, &dec)
+	}
+
+	if act == ACT_NONE {
+		return true
+	}
+
+	// In a turn-based game, wait for our turn
+	if bot.rules.turnBased && bot.turn != bot.p.Num {
+		bot.plan_act = ACT_NONE
+		bot.wakeAt = now.Add(TURN_TIMEOUT)
+		return false
+	}
+
+	if act != bot.plan_act {
+		bot.wakeAt = now.Add(bot.prof.reactionTime(act, bot.rng))
+	}
+	bot.plan_idx = tile_idx
+	bot.plan_act = act
+	bot.plan = dec
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// Update the bot's memory of the board
+// ---------------------------------------------------------------------------
+
+func (bot *memBot_t) onBoard(b string) {
+	now := bot.clock.Now()
+	p := bot.p.Num
+	botmem := bot.botmem
+
+	if b[0] == 'F' { // (F)lipped by this bot
+		bot.flipCnt++
+		botmem[:].botRevealTile(p, b, bot.verbose, now, bot.flipCnt)
+	} else if b[0] == 'O' { // (O)pponent flipped tile
+		bot.flipCnt++
+		bot.opp.observe(now.Sub(bot.lastEventAt))
+		botmem[:].botRevealTile(p, b, bot.verbose, now, bot.flipCnt)
+	} else if b[0] == 'T' { // (T)eammate flipped tile
+		bot.flipCnt++
+		botmem[:].botRevealTile(p, b, bot.verbose, now, bot.flipCnt)
+	} else if b[0] == 'H' { // (H)ide unmatched tiles
+		botmem[:].botHideTiles(p, b, bot.verbose, bot.prof.memPc, bot.rng, now, bot.flipCnt)
+	} else if b[0] == 'R' { // (R)emove matched tiles
+		_, winner := msgTiles(b)
+		bot.pairs[winner]++
+		bot.prof.slowPc = bot.prof.inGameSlowPc(bot.baseSlowPc, bot.lead())
+		botmem[:].botRemoveTiles(p, b, bot.verbose)
+	} else if b[0] == 'P' { // (P)layer's turn
+		bot.turn, _ = strconv.Atoi(b[1:2])
+	}
+	bot.lastEventAt = now
+
+	if bot.awaited(b) {
+		bot.await_idx = -1
+		bot.plan_act = ACT_NONE
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Whether a board update concerns the tile the bot is awaiting
+// ---------------------------------------------------------------------------
 
-		// Sleepy time for 'lil bot-bot
-		// 100% slow (max): Sleep between 1000 and 2000 milliseconds
-		// 10% slow (min): Sleep between 100 and 200 milliseconds
-		s := 10 * p.slowPc
-		time.Sleep(time.Duration(s+rand.Intn(s)) * time.Millisecond)
+func (bot *memBot_t) awaited(b string) bool {
+	if b[0] == 'F' || b[0] == 'O' || b[0] == 'T' {
+		idx, _ := strconv.Atoi(b[1:4])
+		return idx == bot.await_idx
+	}
+	if b[0] == 'E' { // (E)rror: our flip was refused
+		return true
 	}
+	if b[0] != 'H' && b[0] != 'R' {
+		return false
+	}
+
+	tiles, _ := msgTiles(b)
+	for _, idx := range tiles {
+		if idx == bot.await_idx {
+			return true
+		}
+	}
+	return false
 }
 
-func (botmem tilearray_t) dispBotmem(p player_t) {
+// ---------------------------------------------------------------------------
+// Sets the bot (or its team) leads the best of its opponents by
+// ---------------------------------------------------------------------------
+
+func (bot *memBot_t) lead() int {
+	sides := [MAX_SEATS + 1]int{}
+	for q := 1; q < len(bot.pairs); q++ {
+		sides[seatSide(q, bot.rules.teams)] += bot.pairs[q]
+	}
+
+	mySide := seatSide(bot.p.Num, bot.rules.teams)
+	best := 0
+	for side, pairs := range sides {
+		if side != mySide && pairs > best {
+			best = pairs
+		}
+	}
+	return sides[mySide] - best
+}
+
+// ---------------------------------------------------------------------------
+// The bot's reaction time has passed: flip the planned tile and await the
+// board's response. If already awaiting, the flip was lost - think again.
+// If there is no plan (the bot is waiting for its turn), think again too.
+//
+// Returns: tile to flip (or -1 for none), and the decision explained
+// ---------------------------------------------------------------------------
+
+func (bot *memBot_t) wake() (int, *botDecision_t) {
+	if bot.await_idx >= 0 {
+		bot.await_idx = -1
+		bot.plan_act = ACT_NONE
+		return -1, nil
+	}
+	if bot.plan_act == ACT_NONE {
+		return -1, nil
+	}
+
+	dec := bot.plan
+	dec.Bot = bot.p.Num
+	dec.Tile = bot.plan_idx
+	dec.Action = ACT_NAMES[bot.plan_act]
+	dec.Remembered = bot.botmem.remembered()
+	if VerboseGlobal {
+		log.Println("Bot", bot.p.Num, "chose tile", bot.plan_idx, "-", dec.Reason)
+	}
+
+	bot.await_idx = bot.plan_idx
+	bot.wakeAt = bot.clock.Now().Add(BOT_AWAIT_TIMEOUT)
+	return bot.plan_idx, &dec
+}
+
+// ---------------------------------------------------------------------------
+// Draw a human-like reaction time for the given kind of action
+// ---------------------------------------------------------------------------
+
+func (prof *botProfile_t) reactionTime(act int, rng *rand.Rand) time.Duration {
+	r := prof.react[act]
+	ms := float64(r.meanMs) + rng.NormFloat64()*float64(r.sdMs)
+	ms = ms * float64(prof.slowPc) / 100
+	if ms < BOT_MIN_REACT_MS {
+		ms = BOT_MIN_REACT_MS
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (botmem botmem_t) dispBotmem(p player_t) {
 	fmt.Print("Bot ", p.Num, " ")
 	for _, tile := range botmem {
 		if tile.val == NOVAL {
@@ -111,50 +398,133 @@ func (botmem tilearray_t) dispBotmem(p player_t) {
 	fmt.Println("|")
 }
 
-func (botmem tilearray_t) botRevealTile(p int, msg string, verbose bool) {
+func (botmem botmem_t) botRevealTile(p int, msg string, verbose bool, now time.Time, flipCnt int) {
 	msg_id := msg[0]
 	idx, _ := strconv.Atoi(msg[1:4])
 	revealed_val, _ := strconv.Atoi(msg[4:7])
 	if msg_id == 'F' {
 		botmem[idx].disp = FACEUP_ME
+	} else if msg_id == 'T' {
+		botmem[idx].disp = FACEUP_TEAM
 	} else {
 		botmem[idx].disp = FACEUP_OPP
 	}
 	botmem[idx].val = revealed_val
+	botmem[idx].upBy, _ = strconv.Atoi(msg[7:8])
+	botmem[idx].seen(now, flipCnt)
 	if verbose {
 		log.Println("Bot", p, "I flipped", idx, "revealing", revealed_val)
 	}
 }
 
-func (botmem tilearray_t) botHideTiles(p int, msg string, verbose bool, memPercent int) {
-	idx1, _ := strconv.Atoi(msg[1:4])
-	idx2, _ := strconv.Atoi(msg[4:7])
+func (botmem botmem_t) botHideTiles(p int, msg string, verbose bool, memPercent int, rng *rand.Rand, now time.Time, flipCnt int) {
+	tiles, _ := msgTiles(msg)
 
-	botmem[idx1].disp = FACEDOWN
-	botmem[idx2].disp = FACEDOWN
+	// Each card is last seen now, and may not be noticed at all
+	for _, idx := range tiles {
+		botmem[idx].disp = FACEDOWN
+		botmem[idx].seen(now, flipCnt)
+		if rng.Intn(100) >= memPercent {
+			botmem[idx].val = NOVAL
+		}
+	}
 
-	// Possibly forget both cards
-	r := rand.Intn(100)
-	if r >= memPercent {
-		botmem[idx1].val = 0
-		botmem[idx2].val = 0
+	if verbose {
+		log.Println("Bot", p, "vvvv", tiles, "vvvv")
+	}
+}
+
+func (botmem botmem_t) botRemoveTiles(p int, msg string, verbose bool) {
+	tiles, _ := msgTiles(msg)
+	for _, idx := range tiles {
+		botmem[idx].disp = REMOVED
 	}
 
 	if verbose {
-		log.Println("Bot", p, "vvvv", idx1, "vvvv", idx2, "vvvv")
+		log.Println("Bot", p, "MATCHED and removed", tiles)
 	}
 }
 
-func (botmem tilearray_t) botRemoveTiles(p int, msg string, verbose bool) {
-	idx1, _ := strconv.Atoi(msg[1:4])
-	idx2, _ := strconv.Atoi(msg[4:7])
+func (tile *botTile_t) seen(now time.Time, flipCnt int) {
+	tile.seenAt = now
+	tile.seenFlip = flipCnt
+	tile.checkAt = now
+	tile.checkFlip = flipCnt
+}
 
-	botmem[idx1].disp = REMOVED
-	botmem[idx2].disp = REMOVED
+// ---------------------------------------------------------------------------
+// Forget face-down tiles as memory decays. Each check applies the decay
+// since the previous check, so it does not matter how often this is called.
+// Then, if more tiles are held than the bot's capacity, forget the tiles
+// that were seen longest ago.
+// ---------------------------------------------------------------------------
 
-	if verbose {
-		log.Println("Bot", p, "MATCHED and removed", idx1, "and", idx2)
+func (botmem botmem_t) botForget(p int, mem memory_t, capacity int, now time.Time, flipCnt int, rng *rand.Rand) {
+	held := 0
+
+	for t := range botmem {
+		tile := &botmem[t]
+		if tile.disp != FACEDOWN || tile.val == NOVAL {
+			continue
+		}
+
+		recall := 1.0
+		if mem.halfLifeMs > 0 {
+			ms := float64(now.Sub(tile.checkAt).Milliseconds())
+			recall *= math.Pow(0.5, ms/float64(mem.halfLifeMs))
+		}
+		if mem.flipHalfLife > 0 {
+			flips := float64(flipCnt - tile.checkFlip)
+			recall *= math.Pow(0.5, flips/float64(mem.flipHalfLife))
+		}
+		tile.checkAt = now
+		tile.checkFlip = flipCnt
+
+		if rng.Float64() >= recall {
+			tile.val = NOVAL
+			if VerboseGlobal {
+				log.Println("Bot", p, "forgot tile", t)
+			}
+			continue
+		}
+		held++
 	}
+
+	for capacity > 0 && held > capacity {
+		oldest := -1
+		for t, tile := range botmem {
+			if tile.disp != FACEDOWN || tile.val == NOVAL {
+				continue
+			}
+			if oldest < 0 || tile.seenFlip < botmem[oldest].seenFlip ||
+				(tile.seenFlip == botmem[oldest].seenFlip && tile.seenAt.Before(botmem[oldest].seenAt)) {
+				oldest = t
+			}
+		}
+		botmem[oldest].val = NOVAL
+		held--
+		if VerboseGlobal {
+			log.Println("Bot", p, "memory full, forgot tile", oldest)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Number of tiles this bot can hold in memory for one game
+// ---------------------------------------------------------------------------
+
+func (mem memory_t) itemCapacity(rng *rand.Rand) int {
+	if mem.capacity <= 0 {
+		return 0
+	}
+	capacity := mem.capacity
+	if mem.capSd > 0 {
+		capacity += rng.Intn(2*mem.capSd+1) - mem.capSd
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	return capacity
 }
 
 // ---------------------------------------------------------------------------
@@ -162,12 +532,13 @@ func (botmem tilearray_t) botRemoveTiles(p int, msg string, verbose bool) {
 //
 // This function has no state other than the memory-map of the board. i.e. A
 // call to this function independently re-evaluates next move based solely on
-// the current state of the board upon entry.
+// the bot's memory of the board upon entry, and the bot's random numbers.
+// The reason for the choice is noted in dec, to explain it to others.
 //
-// Returns: tile index to flip next
+// Returns: tile index to flip next, and the kind of action
 // ---------------------------------------------------------------------------
 
-func botChoose(p int, botmem tilearray_t) (int, bool) {
+func botChoose(p int, botmem botmem_t, setSize int, rng *rand.Rand, dec *botDecision_t) (int, int) {
 	if VerboseGlobal {
 		log.Printf("Bot %d Make a choice\n", p)
 	}
@@ -176,18 +547,27 @@ func botChoose(p int, botmem tilearray_t) (int, bool) {
 	// Also ensure there is at least one face-down tile on board.
 	myTilesUpCnt := 0
 	myTileVal := 0
+	myTilesMatch := true
 	oppTilesUpCnt := 0
-	oppTileVal := 0
+	oppTiles := []botTile_t{}
+	teamTiles := []botTile_t{}
+	upCnt := make(map[[2]int]int) // Face-up tiles by player and value
 	faceDownCnt := 0
 
 	for _, tile := range botmem {
 		if tile.disp == FACEUP_ME {
+			if myTilesUpCnt > 0 && tile.val != myTileVal {
+				myTilesMatch = false
+			}
 			myTilesUpCnt++
 			myTileVal = tile.val
-			//myTileNum = t
 		} else if tile.disp == FACEUP_OPP {
 			oppTilesUpCnt++
-			oppTileVal = tile.val
+			oppTiles = append(oppTiles, tile)
+			upCnt[[2]int{tile.upBy, tile.val}]++
+		} else if tile.disp == FACEUP_TEAM {
+			teamTiles = append(teamTiles, tile)
+			upCnt[[2]int{tile.upBy, tile.val}]++
 		} else if tile.disp == FACEDOWN {
 			faceDownCnt++
 		}
@@ -195,80 +575,114 @@ func botChoose(p int, botmem tilearray_t) (int, bool) {
 
 	// If no tiles face-down, end of game for this bot
 	if faceDownCnt == 0 {
-		return 0, true
+		return 0, ACT_NONE
 	}
 
 	if VerboseGlobal {
 		log.Println("Bot", p, "found", myTilesUpCnt, "and", oppTilesUpCnt, "tiles face up")
 	}
 
-	// If you have one tile upturned (second move), there are three possibilities:
+	// If you have part of a set upturned (second move), there are three
+	// possibilities:
 	// 1. We remember a hidden tile with same value -> choose it
 	// 2. Our opponent has upturned same tile as us -> choose a random tile
 	// 3. No knowledge of tile -> choose a random tile
 
-	if myTilesUpCnt == 1 {
+	if myTilesUpCnt > 0 && myTilesUpCnt < setSize && myTilesMatch {
 		for t, tile := range botmem {
 			if tile.disp == FACEDOWN && tile.val == myTileVal {
-				if VerboseGlobal {
-					log.Println("Bot", p, "choose known match", t)
-				}
-				return t, false
+				dec.Reason = "choose known match"
+				dec.Candidates = []int{t}
+				return t, ACT_SECOND
 			}
 		}
 
-		randTile := randomChoice(faceDownCnt, botmem[:])
-		if VerboseGlobal {
-			log.Println("Bot", p, "chooses random tile for second move", randTile)
-		}
-		return randTile, false
+		randTile := randomChoice(faceDownCnt, botmem[:], rng)
+		dec.Reason = "random tile for second move"
+		dec.Candidates = botmem.faceDown()
+		return randTile, ACT_SECOND
 	}
 
 	// Guzump is possible on move 1, scan memory for face-down match of
-	// opponent's tile
-	if oppTilesUpCnt == 1 {
+	// any opponent's set that lacks only one tile
+	for _, oppTile := range oppTiles {
+		if upCnt[[2]int{oppTile.upBy, oppTile.val}] != setSize-1 {
+			continue
+		}
 		for t, tile := range botmem {
-			if tile.disp == FACEDOWN && tile.val == oppTileVal {
+			if tile.disp == FACEDOWN && tile.val == oppTile.val {
 				// Try to guzump. This is a race most likely won by opponent.
-				if VerboseGlobal {
-					log.Println("Bot", p, "try guzump tile", t)
-				}
-				return t, false
+				dec.Reason = "try guzump"
+				dec.Candidates = []int{t}
+				return t, ACT_GUZUMP
 			}
 		}
 	}
 
-	// If move 1 (bot has either zero or two tiles upturned)
-	// Scan for face-down pairs: If pair found, pick one of these tile randomly
+	// In a team game, a teammate's face-up tiles can be completed as if they
+	// were our own
+	for _, teamTile := range teamTiles {
+		if upCnt[[2]int{teamTile.upBy, teamTile.val}] != setSize-1 {
+			continue
+		}
+		for t, tile := range botmem {
+			if tile.disp == FACEDOWN && tile.val == teamTile.val {
+				dec.Reason = "complete teammate's set"
+				dec.Candidates = []int{t}
+				return t, ACT_SECOND
+			}
+		}
+	}
+
+	// If move 1 (bot has no tiles upturned, or tiles that failed to match)
+	// Scan for a face-down set: If found, pick one of its tiles randomly
 	// Otherwise, choose a random tile
 
-	knownValues := make([]int, int(cap(botmem)/2))
+	knownTiles := make(map[int][]int)
 	for t, tile := range botmem {
 		if tile.disp == FACEDOWN && tile.val != NOVAL {
-			idx := tile.val - 1
-			if knownValues[idx] == NOVAL {
-				knownValues[idx] = t
+			known := append(knownTiles[tile.val], t)
+			knownTiles[tile.val] = known
+			if len(known) < setSize {
 				continue
 			}
 
-			// This ID is non-zero, so have found a pair
-			// Randomly choose either the first or second tile of the pair
-			choice := knownValues[idx]
-			if rand.Intn(2) == 0 {
-				choice = t
-			}
-			if VerboseGlobal {
-				log.Println("Bot", p, "chooses first tile of known pair", choice)
-			}
-			return choice, false
+			// Have found a whole set. Randomly choose one of its tiles
+			dec.Reason = "first tile of known set"
+			dec.Candidates = known
+			return known[rng.Intn(len(known))], ACT_FIRST
 		}
 	}
 
-	randTile := randomChoice(faceDownCnt, botmem[:])
-	if VerboseGlobal {
-		log.Println("Bot", p, "chooses random tile for first move", randTile)
+	randTile := randomChoice(faceDownCnt, botmem[:], rng)
+	dec.Reason = "random tile for first move"
+	dec.Candidates = botmem.faceDown()
+	return randTile, ACT_FIRST
+}
+
+// ---------------------------------------------------------------------------
+// The bot's view of the face-down tiles: their indexes, and the values it
+// remembers for them
+// ---------------------------------------------------------------------------
+
+func (botmem botmem_t) faceDown() []int {
+	tiles := []int{}
+	for t, tile := range botmem {
+		if tile.disp == FACEDOWN {
+			tiles = append(tiles, t)
+		}
+	}
+	return tiles
+}
+
+func (botmem botmem_t) remembered() map[int]int {
+	known := make(map[int]int)
+	for t, tile := range botmem {
+		if tile.disp == FACEDOWN && tile.val != NOVAL {
+			known[t] = tile.val
+		}
 	}
-	return randTile, false
+	return known
 }
 
 // ---------------------------------------------------------------------------
@@ -277,8 +691,8 @@ func botChoose(p int, botmem tilearray_t) (int, bool) {
 // Returns: tile to flip next
 // ---------------------------------------------------------------------------
 
-func randomChoice(faceDownCnt int, botmem tilearray_t) int {
-	r := rand.Intn(faceDownCnt)
+func randomChoice(faceDownCnt int, botmem botmem_t, rng *rand.Rand) int {
+	r := rng.Intn(faceDownCnt)
 
 	for t, tile := range botmem {
 		if tile.disp == FACEDOWN {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testBot(num int, rules rules_t, seed int64) (*memBot_t, *virtualClock_t) {
+	VerboseGlobal = false
+	clock := &virtualClock_t{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := player_t{Name: BotProfiles[1].Name, Num: num, IsBot: true, bot: &BotProfiles[1]}
+	return newMemBot(p, rules, false, clock, seed), clock
+}
+
+// ---------------------------------------------------------------------------
+// A bot is never given the board: what it does depends only on its seed and
+// the events it is sent. Two bots with one seed, one of them playing board A
+// and the other sent the same events while board B is dealt, choose the same
+// tiles for the same reasons.
+// ---------------------------------------------------------------------------
+
+func TestDecisionsDependOnlyOnEvents(t *testing.T) {
+	rules := rules_t{tMax: 12, seats: 1, setSize: 2}
+	boardA := make(tilearray_t, rules.tMax)
+	boardB := make(tilearray_t, rules.tMax)
+	initBoard(rules.tMax, rules.setSize, boardA, rand.New(rand.NewSource(1)))
+	initBoard(rules.tMax, rules.setSize, boardB, rand.New(rand.NewSource(2)))
+	if reflect.DeepEqual(boardA, boardB) {
+		t.Fatal("boards dealt alike")
+	}
+
+	botA, clockA := testBot(1, rules, 7)
+	botB, clockB := testBot(1, rules, 7)
+	game := game_t{Tmax: rules.tMax, SetSize: rules.setSize, clock: clockA}
+	game.Players = []player_t{{Num: 1, IsBot: true, board: make(chan string, 10)}}
+	game.clearScores()
+	game.replay = newReplay(&game, boardA, 1)
+
+	flips := 0
+	for !botA.think() {
+		if botB.think() {
+			t.Fatal("bot B sees the game over before bot A")
+		}
+		clockA.advanceTo(botA.wakeAt)
+		clockB.advanceTo(botB.wakeAt)
+		idxA, decA := botA.wake()
+		idxB, decB := botB.wake()
+		if idxA != idxB || !reflect.DeepEqual(decA, decB) {
+			t.Fatalf("flip %d: bot A chose %d (%v), bot B %d (%v)", flips, idxA, decA, idxB, decB)
+		}
+		if idxA < 0 {
+			continue
+		}
+
+		flips++
+		if flips > 10*rules.tMax {
+			t.Fatal("game not finished")
+		}
+		flipTile(&game, 1, idxA, boardA)
+		for len(game.Players[0].board) > 0 {
+			msg := <-game.Players[0].board
+			botA.onBoard(msg)
+			botB.onBoard(msg)
+		}
+	}
+	if !isGameFinished(boardA) {
+		t.Fatal("bot A stopped before the game finished")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A bot waiting for its turn has no plan. If its wait runs out before the
+// turn comes, it flips nothing and thinks again.
+// ---------------------------------------------------------------------------
+
+func TestWakeWaitingForTurn(t *testing.T) {
+	bot, clock := testBot(1, rules_t{tMax: 12, seats: 2, setSize: 2, turnBased: true}, 1)
+	bot.onBoard("P2")
+
+	if bot.think() {
+		t.Fatal("think: no face-down tiles on a new board")
+	}
+	clock.advanceTo(bot.wakeAt)
+	if idx, dec := bot.wake(); idx != -1 || dec != nil {
+		t.Fatalf("wake on the opponent's turn: tile %d, decision %v; want -1, nil", idx, dec)
+	}
+
+	bot.onBoard("P1")
+	bot.think()
+	clock.advanceTo(bot.wakeAt)
+	if idx, dec := bot.wake(); idx < 0 || dec == nil {
+		t.Fatalf("wake on the bot's turn: tile %d, decision %v; want a flip", idx, dec)
+	}
+}
@@ -17,6 +17,7 @@ var buttonArray = [];
 // ---------------------------------------------------------------------------
 
 document.addEventListener("DOMContentLoaded", startWebsocket())
+document.addEventListener("DOMContentLoaded", checkAccount)
 
 function startWebsocket() {
   socket = new WebSocket("wss://127.0.0.1:8088/game/");
@@ -45,6 +46,9 @@ function startWebsocket() {
         case "GamesInProgress":
                          createGameSelector(msg_obj.Games);
                          break;
+        case "GameUpdate":
+                         updateGameSelector(msg_obj.Idx, msg_obj.Game);
+                         break;
         case "Flipped":  flipTile(msg_obj);
                          break;
         case "Hidden":   hideTiles(msg_obj);
@@ -53,6 +57,21 @@ function startWebsocket() {
                          break;
         case "Finished": removeTiles(msg_obj);
                          break;
+        case "BotDecision":
+                         showBotDecision(msg_obj.Decision);
+                         break;
+        case "Turn":     showTurn(msg_obj);
+                         break;
+        case "Error":    showStatus(msg_obj.Msg);
+                         break;
+        case "Latency":  showLatency(msg_obj);
+                         break;
+        case "Invite":   showInvite(msg_obj);
+                         break;
+        case "Matched":  showMatch(msg_obj);
+                         break;
+        case "Watching": showWatching(msg_obj);
+                         break;
         default:         alert("Unknown message", msg_obj);
       }
   });
@@ -60,6 +79,7 @@ function startWebsocket() {
   socket.onclose = event => {
     console.log("Socket Closed Connection: ", event);
     SessionStatus = state.UNCONNECTED;
+    showStatus("Disconnected from the server")
   };
 
   socket.onerror = error => {
@@ -69,66 +89,264 @@ function startWebsocket() {
 };
 
 // ---------------------------------------------------------------------------
-// Game Selector setup
+// Game Selector setup. The lobby shows every game in progress and one empty
+// slot below them for a new game, and is kept up to date as they change.
 // ---------------------------------------------------------------------------
 
+var LobbyGames = []
+var gameLines = []
+
 function createGameSelector(gameArray) {
   if (SessionStatus != state.CONNECTED) {
     console.log("Cannot show game selector in this state")
     return
   }
 
-  // Find last non-empty game, and provide one extra new game below
-  for (maxg = gameArray.length; maxg > 0 ; maxg--) {
-    if (gameArray[maxg-1].Status != 0) break;
+  LobbyGames = gameArray
+  gameLines = []
+  document.querySelector(".gameSelect").replaceChildren()
+  showGameLines()
+
+  // A link to a private game carries its invite code
+  let invite = new URLSearchParams(window.location.search).get("invite")
+  if (invite) {
+    document.getElementById("inviteCode").value = invite
   }
-  if (maxg != gameArray.length) {maxg++}
+  document.getElementById("inviteJoin").onclick = joinInviteReq
+  document.getElementById("inviteWatch").onclick = watchInviteReq
+  document.getElementById("findMatch").onclick = findMatchReq
+  document.getElementById("cancelMatch").onclick = cancelMatchReq
+}
+
+// ---------------------------------------------------------------------------
+// Accounts. A logged-in player plays under their account's name; anyone
+// else plays as a guest, under the name typed in (if nobody owns it). The
+// server knows who is logged in by the session cookie the socket is opened
+// with, so logging in or out in the lobby reconnects.
+// ---------------------------------------------------------------------------
+
+var Account = ""
+
+function playerName() {
+  return Account || document.getElementById("loginName").value.trim() || "Guest"
+}
+
+function checkAccount() {
+  document.getElementById("login").onclick = function () { accountReq("/account/login") }
+  document.getElementById("register").onclick = function () { accountReq("/account/register") }
+  document.getElementById("logout").onclick = logoutReq
+
+  fetch("/account/me").then(function (resp) {
+    return resp.ok ? resp.json() : {Name: ""}
+  }).then(function (me) {
+    showAccount(me.Name)
+  })
+}
+
+function accountReq(url) {
+  let cred = {"Name": document.getElementById("loginName").value.trim(),
+              "Password": document.getElementById("loginPassword").value}
+  fetch(url, {method: "POST", body: JSON.stringify(cred)}).then(function (resp) {
+    if (!resp.ok) {
+      resp.text().then(showStatus)
+      return
+    }
+    resp.json().then(function (me) {
+      document.getElementById("loginPassword").value = ""
+      showStatus("")
+      showAccount(me.Name)
+      reconnect()
+    })
+  })
+}
+
+function logoutReq() {
+  fetch("/account/logout", {method: "POST"}).then(function () {
+    showAccount("")
+    reconnect()
+  })
+}
+
+function showAccount(name) {
+  Account = name
+  document.getElementById("accountName").textContent = name ? "Playing as " + name : ""
+  document.getElementById("loginForm").style.display = name ? "none" : ""
+  document.getElementById("logout").style.display = name ? "" : "none"
+}
+
+function reconnect() {
+  if (SessionStatus != state.CONNECTED) {
+    return
+  }
+  socket.onclose = null
+  socket.close()
+  startWebsocket()
+}
+
+function updateGameSelector(g, game) {
+  if (SessionStatus != state.CONNECTED) {
+    return
+  }
+
+  LobbyGames[g] = game
+  if (g < gameLines.length) {
+    let line = createGameLine(g, game)
+    gameLines[g].replaceWith(line)
+    gameLines[g] = line
+  }
+  showGameLines()
+}
+
+// Add lines down to the last non-empty game, and one extra new game below
+function showGameLines() {
+  for (maxg = LobbyGames.length; maxg > 0 ; maxg--) {
+    if (LobbyGames[maxg-1].Status != 0) break;
+  }
+  if (maxg != LobbyGames.length) {maxg++}
 
   gameSel = document.querySelector(".gameSelect");
-  for (let g = 0; g < maxg; g++) {
-    var newGameLineSpc = document.createElement("div")
-    newGameLineSpc.setAttribute("class", "gameLineSpace")
-    gameSel.appendChild(newGameLineSpc)
-
-    var newGameLine = document.createElement("div")
-    newGameLine.setAttribute("class", "gameLine")
-    newGameLine.setAttribute("id", g)
-    newGameLineSpc.appendChild(newGameLine)
-
-    var newGameStatus = document.createElement("div")
-    newGameStatus.setAttribute("class", "gameStatus")
-    newGameStatus.setAttribute("id", g)
-    if (gameArray[g].Status === 2) {
-      newGameStatus.innerHTML = "In Progress"
-    } else if (gameArray[g].Status === 1) {
-      newGameStatus.innerHTML = "Waiting"
-    } else if (gameArray[g].Status === 0) {
-      var newGameButton = document.createElement("button")
-      var buttonText = document.createTextNode("New Game");
-      newGameButton.appendChild(buttonText);
-      newGameButton.setAttribute("id", g);
-      newGameButton.onclick = newGameReq
-      newGameStatus.appendChild(newGameButton);
+  for (let g = gameLines.length; g < maxg; g++) {
+    gameLines[g] = createGameLine(g, LobbyGames[g])
+    gameSel.appendChild(gameLines[g])
+  }
+}
+
+function createGameLine(g, game) {
+  var newGameLineSpc = document.createElement("div")
+  newGameLineSpc.setAttribute("class", "gameLineSpace")
+
+  var newGameLine = document.createElement("div")
+  newGameLine.setAttribute("class", "gameLine")
+  newGameLine.setAttribute("id", g)
+  newGameLineSpc.appendChild(newGameLine)
+
+  var newGameStatus = document.createElement("div")
+  newGameStatus.setAttribute("class", "gameStatus")
+  newGameStatus.setAttribute("id", g)
+  var players = game.Players || []
+  if (game.Private) {
+    newGameStatus.innerHTML = "Private"
+  } else if (game.Status === 2) {
+    newGameStatus.innerHTML = "In Progress "
+    var watchButton = document.createElement("button")
+    watchButton.appendChild(document.createTextNode("Watch"))
+    watchButton.onclick = function () { spectateReq({"Idx":g|0}) }
+    newGameStatus.appendChild(watchButton)
+  } else if (game.Status === 1) {
+    // One join button, or in a team game one per team
+    let seated = players.filter(p => p.Num != 0).length
+    let teams = game.Teams
+    for (let team = (teams > 0 ? 1 : 0); team <= teams; team++) {
+      var joinButton = document.createElement("button")
+      let label = "Join " + seated + "/" + game.Seats
+      if (team > 0) {
+        label = "Join team " + team
+      }
+      joinButton.appendChild(document.createTextNode(label));
+      joinButton.setAttribute("id", g);
+      joinButton.onclick = function () { joinGameReq(g, team, game.Tmax) }
+      newGameStatus.appendChild(joinButton);
     }
-    newGameLine.appendChild(newGameStatus)
-
-    var newGameP1 = document.createElement("div")
-    newGameP1.setAttribute("class", "gameP1")
-    if (gameArray[g].Status != 0) {
-      newGameP1.innerHTML = gameArray[g].P1.Name
-    } else {
-      var nameForm = document.createElement("form")
-      var nameInput = document.createElement("input")
-      nameForm.appendChild(nameInput);
-      newGameP1.appendChild(nameForm);
+  } else if (game.Status === 0) {
+    var newGameButton = document.createElement("button")
+    var buttonText = document.createTextNode("New Game");
+    newGameButton.appendChild(buttonText);
+    newGameButton.setAttribute("id", g);
+    newGameButton.onclick = newGameReq
+    newGameStatus.appendChild(newGameButton);
+  }
+  newGameLine.appendChild(newGameStatus)
+
+  var newGameP1 = document.createElement("div")
+  newGameP1.setAttribute("class", "gameP1")
+  if (game.Status != 0) {
+    newGameP1.textContent = players.length > 0 ? players[0].Name : ""
+  } else {
+    var nameForm = document.createElement("form")
+    var nameInput = document.createElement("input")
+    nameForm.appendChild(nameInput);
+    newGameP1.appendChild(nameForm);
+  }
+  newGameLine.appendChild(newGameP1)
+
+  // Other seats: the players seated so far, or for a new game, the number
+  // of seats and how many of them bots fill
+  var newGameP2 = document.createElement("div")
+  newGameP2.setAttribute("class", "gameP2")
+  if (game.Status != 0) {
+    newGameP2.textContent = players.slice(1).map(seatName).join(", ")
+    if (game.TurnBased) {
+      newGameP2.textContent += " (turns)"
     }
-    newGameLine.appendChild(newGameP1)
+  } else {
+    newGameP2.appendChild(seatSelector("seats"+g, 2, 6, 2, "Players "))
+    newGameP2.appendChild(seatSelector("teams"+g, 0, 3, 0, " Teams "))
+    newGameP2.appendChild(seatSelector("setsize"+g, 2, 4, 2, " Match "))
+    newGameP2.appendChild(checkbox("turns"+g, "Turns"))
+    newGameP2.appendChild(seatSelector("bots"+g, 0, 5, 1, " Bots "))
+    newGameP2.appendChild(choiceSelector("botafter"+g, [0, 15, 30, 60], 0, " Bots after (s) "))
+    newGameP2.appendChild(checkbox("private"+g, "Private"))
+    var password = document.createElement("input")
+    password.setAttribute("type", "password")
+    password.setAttribute("id", "password"+g)
+    password.setAttribute("placeholder", "Password")
+    password.setAttribute("size", "8")
+    newGameP2.appendChild(password)
+  }
+  /*newGameStatus.addEventListener("click", flipTile)*/
+  newGameLine.appendChild(newGameP2)
+
+  return newGameLineSpc
+}
+
+function checkbox(id, text) {
+  var label = document.createElement("label")
+  var box = document.createElement("input")
+  box.setAttribute("type", "checkbox")
+  box.setAttribute("id", id)
+  label.appendChild(box)
+  label.appendChild(document.createTextNode(text))
+  return label
+}
+
+function seatName(player) {
+  let name = player.Num != 0 ? player.Name : "(empty)"
+  if (player.Team > 0) {
+    name += " [team " + player.Team + "]"
+  }
+  return name
+}
+
+function choiceSelector(id, choices, selected, label) {
+  var span = document.createElement("span")
+  span.appendChild(document.createTextNode(label))
+  var sel = document.createElement("select")
+  sel.setAttribute("id", id)
+  for (const n of choices) {
+    var opt = document.createElement("option")
+    opt.value = n
+    opt.text = n === 0 ? "never" : n
+    opt.selected = (n === selected)
+    sel.appendChild(opt)
+  }
+  span.appendChild(sel)
+  return span
+}
 
-    var newGameP2 = document.createElement("div")
-    newGameP2.setAttribute("class", "gameP2")
-    /*newGameStatus.addEventListener("click", flipTile)*/
-    newGameLine.appendChild(newGameP2)
+function seatSelector(id, min, max, selected, label) {
+  var span = document.createElement("span")
+  span.appendChild(document.createTextNode(label))
+  var sel = document.createElement("select")
+  sel.setAttribute("id", id)
+  for (let n = min; n <= max; n++) {
+    var opt = document.createElement("option")
+    opt.value = n
+    opt.text = n
+    opt.selected = (n === selected)
+    sel.appendChild(opt)
   }
+  span.appendChild(sel)
+  return span
 }
 
 // ---------------------------------------------------------------------------
@@ -170,7 +388,15 @@ function resetBoard(tMax) {
 //    NewGame
 //    {Idx: int
 //     Tmax: int
-//     Bot: int
+//     Seats: int
+//     Teams: int
+//     SetSize: int
+//     TurnBased: bool
+//     OppBot: int
+//     Bots: int
+//     BotAfter: int
+//     Private: bool
+//     Password: string
 //     Name: string}
 // ---------------------------------------------------------------------------
 
@@ -185,11 +411,29 @@ function newGameReq(event) {
   }
 
   let Tmax = 20      // TODO
-  let Name = "Neil"  // TODO
+  let Name = playerName()
   let OppBot = 1     // TODO
+  let Seats = document.getElementById("seats"+g).value|0
+  let Teams = document.getElementById("teams"+g).value|0
+  if (Teams === 1 || Teams >= Seats || Seats % Teams != 0) {
+    Teams = 0
+  }
+  // Deal whole sets only: 18 tiles for triples
+  let SetSize = document.getElementById("setsize"+g).value|0
+  Tmax -= Tmax % SetSize
+  let TurnBased = document.getElementById("turns"+g).checked
+  let Private = document.getElementById("private"+g).checked
+  let Password = Private ? document.getElementById("password"+g).value : ""
+  let Bots = Math.min(document.getElementById("bots"+g).value|0, Seats-1)
+  if (Bots === 0) {
+    OppBot = 0
+  }
+  let BotAfter = document.getElementById("botafter"+g).value|0
   //let OppBot   = document.getElementsByName("mapHeightParam")[0].value;
 
-  newGameStruct = {"Idx":g|0, "Tmax":Tmax|0, "OppBot":OppBot|0, "Name":Name};
+  newGameStruct = {"Idx":g|0, "Tmax":Tmax|0, "Seats":Seats, "Teams":Teams, "SetSize":SetSize, "TurnBased":TurnBased,
+                   "OppBot":OppBot|0, "Bots":Bots, "BotAfter":BotAfter, "Private":Private, "Password":Password,
+                   "Name":Name};
   newGameJSON = JSON.stringify(newGameStruct);
 
   if (socket.readyState != WebSocket.OPEN) {
@@ -209,35 +453,173 @@ function newGameReq(event) {
 };
 
 // ---------------------------------------------------------------------------
-// Send request to Join game
+// Send request to Join game in the next free seat (on a team, if not 0)
 //    JoinGame
 //    {Idx: int
+//     Team: int
 //     Name: string}
 // ---------------------------------------------------------------------------
 
-function joinGameReq(g) {
+function joinGameReq(g, Team, Tmax) {
   console.log("Join Game Button selected")
-  let Name = "Neil"  // TODO
+
+  if (SessionStatus != state.CONNECTED) {
+    console.log("Cannot join game in status", SessionStatus)
+    return
+  }
+
+  let Name = playerName()
   //let Bot   = document.getElementsByName("mapHeightParam")[0].value;
 
-  joinGameStruct = {"Idx":g|0, "Name":Name};
+  joinGameStruct = {"Idx":g|0, "Team":Team|0, "Name":Name};
   joinGameJSON = JSON.stringify(joinGameStruct);
 
   if (socket.readyState === WebSocket.OPEN) {
     socket.send("JoinGame"+joinGameJSON);
   } else {
     console.log("Socket died!");
+    return
   }
+
+  createBoard(Tmax)
+  SessionStatus = state.PLAYING;
+};
+
+// ---------------------------------------------------------------------------
+// Send request to be found a match, rather than pick a game. The board is set
+// up when the server has found an opponent (or a bot).
+//    FindMatch
+//    {Tmax: int
+//     SetSize: int
+//     TurnBased: bool
+//     Name: string}
+// ---------------------------------------------------------------------------
+
+function findMatchReq() {
+  if (SessionStatus != state.CONNECTED) {
+    console.log("Cannot find a match in status", SessionStatus)
+    return
+  }
+
+  let SetSize = document.getElementById("matchSetSize").value|0
+  let Tmax = document.getElementById("matchTiles").value|0
+  Tmax -= Tmax % SetSize
+  let TurnBased = document.getElementById("matchTurns").checked
+  let Name = playerName()
+
+  findMatchJSON = JSON.stringify({"Tmax":Tmax, "SetSize":SetSize, "TurnBased":TurnBased,
+                                  "Name":Name});
+
+  if (socket.readyState === WebSocket.OPEN) {
+    socket.send("FindMatch"+findMatchJSON);
+  } else {
+    console.log("Socket died!");
+    return
+  }
+
+  SessionStatus = state.PLAYING;
+  document.getElementById("cancelMatch").hidden = false
+  showStatus("Looking for an opponent...")
+};
+
+// ---------------------------------------------------------------------------
+// Stop looking for a match. Closing the socket takes the player out of the
+// queue; a new one goes back to the lobby.
+// ---------------------------------------------------------------------------
+
+function cancelMatchReq() {
+  let cancel = document.getElementById("cancelMatch")
+  if (SessionStatus != state.PLAYING || cancel.hidden) {
+    return
+  }
+  cancel.hidden = true
+  socket.onclose = null
+  socket.close()
+  showStatus("")
+  startWebsocket()
+}
+
+// ---------------------------------------------------------------------------
+// Send request to Join a private game by its invite code. The board is set
+// up when the server confirms with the code and the board size.
+//    JoinGame
+//    {Code: string
+//     Password: string
+//     Name: string}
+// ---------------------------------------------------------------------------
+
+function joinInviteReq() {
+  if (SessionStatus != state.CONNECTED) {
+    console.log("Cannot join game in status", SessionStatus)
+    return
+  }
+
+  let Code = document.getElementById("inviteCode").value.trim().toUpperCase()
+  let Password = document.getElementById("invitePassword").value
+  let Name = playerName()
+  if (Code === "") {
+    return
+  }
+
+  joinGameJSON = JSON.stringify({"Code":Code, "Password":Password, "Name":Name});
+
+  if (socket.readyState === WebSocket.OPEN) {
+    socket.send("JoinGame"+joinGameJSON);
+  } else {
+    console.log("Socket died!");
+    return
+  }
+
+  SessionStatus = state.PLAYING;
+};
+
+// ---------------------------------------------------------------------------
+// Send request to watch a running game, by its slot or (for a private game)
+// its invite code. The board is set up when the server sends it.
+//    Spectate
+//    {Idx: int
+//     Code: string
+//     Password: string
+//     Explain: bool}
+// ---------------------------------------------------------------------------
+
+function spectateReq(spectate) {
+  if (SessionStatus != state.CONNECTED) {
+    console.log("Cannot watch a game in status", SessionStatus)
+    return
+  }
+
+  spectate.Explain = document.getElementById("watchExplain").checked
+  spectateJSON = JSON.stringify(spectate);
+
+  if (socket.readyState === WebSocket.OPEN) {
+    socket.send("Spectate"+spectateJSON);
+  } else {
+    console.log("Socket died!");
+    return
+  }
+
+  SessionStatus = state.PLAYING;
+};
+
+function watchInviteReq() {
+  let Code = document.getElementById("inviteCode").value.trim().toUpperCase()
+  let Password = document.getElementById("invitePassword").value
+  if (Code === "") {
+    return
+  }
+  spectateReq({"Code":Code, "Password":Password})
 };
 
 // ---------------------------------------------------------------------------
 // Send request to Flip a tile
 //    FlipTile
-//    {Idx: int}
+//    {Tile: int}
 // ---------------------------------------------------------------------------
 
-function flipTileReq(t) {
-  flipTileStruct = {"Idx":t|0};
+function flipTileReq(event) {
+  let t = event.target.getAttribute("id").slice(4)  // "tile<n>"
+  flipTileStruct = {"Tile":t|0};
   flipTileJSON = JSON.stringify(flipTileStruct);
 
   if (socket.readyState === WebSocket.OPEN) {
@@ -251,49 +633,161 @@ function flipTileReq(t) {
 // Handle Flipped message
 //    Tile:  int
 //    MyTile: boolean
+//    Teammate: boolean
+//    Player: int (1 to 6)
 //    Display: image path string
+//
+// Our own tiles are red, and our teammates' pink; each opponent's are the
+// colour of their seat.
 // ---------------------------------------------------------------------------
 
+const seatClasses = ["faceUpBlue", "faceUpAqua", "faceUpGreen", "faceUpOrange", "faceUpPurple"]
+
 function flipTile(msgObj) {
   tile = document.getElementById("tile"+msgObj.Tile)
   tile.setAttribute("src", msgObj.Display)
-  tile.setAttribute("class", msgObj.MyTile ? "faceUpRed" : "faceUpBlue")
+  if (msgObj.MyTile === "true") {
+    tile.setAttribute("class", "faceUpRed")
+  } else if (msgObj.Teammate === "true") {
+    tile.setAttribute("class", "faceUpPink")
+  } else {
+    tile.setAttribute("class", seatClasses[(msgObj.Player-1) % seatClasses.length])
+  }
 }
 
 // ---------------------------------------------------------------------------
 // Handle Hide message
-//    Tile1:  int
-//    Tile2:  int
+//    Tiles:  string (comma separated tile numbers)
 // ---------------------------------------------------------------------------
 
 function hideTiles(msgObj) {
   sleep(400)
-  t1 = document.getElementById("tile"+msgObj.Tile1)
-  t1.setAttribute("src", "static/Tile1_150.png")
-  t1.setAttribute("class", "faceDown")
-  t2 = document.getElementById("tile"+msgObj.Tile2)
-  t2.setAttribute("src", "static/Tile1_150.png")
-  t2.setAttribute("class", "faceDown")
+  for (const t of msgObj.Tiles.split(",")) {
+    let tile = document.getElementById("tile"+t)
+    tile.setAttribute("src", "static/Tile1_150.png")
+    tile.setAttribute("class", "faceDown")
+  }
 }
 
 // ---------------------------------------------------------------------------
 // Handle Remove message
-//    Tile1:  int
-//    Tile2:  int
+//    Tiles:  string (comma separated tile numbers)
+//    Player: int
 // ---------------------------------------------------------------------------
 
 function removeTiles(msgObj) {
-  t1 = document.getElementById("tile"+msgObj.Tile1)
-  t2 = document.getElementById("tile"+msgObj.Tile2)
-  t1.className += ' item-fade';
-  t2.className += ' item-fade';
+  let tiles = msgObj.Tiles.split(",").map(t => document.getElementById("tile"+t))
+  for (const tile of tiles) {
+    tile.className += ' item-fade';
+  }
   sleep(800)
 
-  t1.setAttribute("src", "static/Blank150.png")
-  t1.setAttribute("class", "empty")
+  for (const tile of tiles) {
+    tile.setAttribute("src", "static/Blank150.png")
+    tile.setAttribute("class", "empty")
+  }
+}
+
+// ---------------------------------------------------------------------------
+// Handle Turn message (turn-based games)
+//    Player: int
+//    MyTurn: bool
+// ---------------------------------------------------------------------------
+
+function showTurn(msgObj) {
+  if (msgObj.MyTurn === "true") {
+    showStatus("Your turn")
+  } else {
+    showStatus("Player " + msgObj.Player + "'s turn")
+  }
+}
+
+// ---------------------------------------------------------------------------
+// Handle Invite message - seated in a private game
+//    Code: string
+//    Tmax: int
+// ---------------------------------------------------------------------------
+
+function showInvite(msgObj) {
+  if (document.getElementById("tile0") === null) {
+    createBoard(msgObj.Tmax)
+  }
+  let link = window.location.origin + window.location.pathname + "?invite=" + msgObj.Code
+  showStatus("Private game - invite code " + msgObj.Code + " or " + link)
+}
+
+// ---------------------------------------------------------------------------
+// Handle Matched message - seated in a game found by matchmaking
+//    Player: int
+//    Players: [string]
+//    Tmax: int
+// ---------------------------------------------------------------------------
+
+function showMatch(msgObj) {
+  document.getElementById("cancelMatch").hidden = true
+  if (document.getElementById("tile0") === null) {
+    createBoard(msgObj.Tmax)
+  }
+  showStatus(msgObj.Players.join(" vs ") + " - you are player " + msgObj.Player)
+}
+
+// ---------------------------------------------------------------------------
+// Handle Watching message - the board of the game being watched, sent when
+// watching starts and as each new game starts. The tiles face up, the sets
+// won and whose turn it is follow.
+//    Tmax: int
+//    Players: [string]
+//    Turns: bool
+// ---------------------------------------------------------------------------
+
+function showWatching(msgObj) {
+  document.querySelector(".grid").replaceChildren()
+  createBoard(msgObj.Tmax)
+  let mode = msgObj.Turns ? " (turns)" : ""
+  showStatus("Watching " + msgObj.Players.join(" vs ") + mode)
+}
+
+// ---------------------------------------------------------------------------
+// Show a line of status (whose turn it is, or an error from the server)
+// ---------------------------------------------------------------------------
 
-  t2.setAttribute("src", "static/Blank150.png")
-  t2.setAttribute("class", "empty")
+function showStatus(text) {
+  document.getElementById("status").textContent = text
+}
+
+// ---------------------------------------------------------------------------
+// Handle Latency message - every player's ping, in seat order
+//    Rtt: string (comma separated round-trip times in ms, "-" for a bot)
+// ---------------------------------------------------------------------------
+
+function showLatency(msgObj) {
+  let pings = msgObj.Rtt.split(",").map(function (rtt, i) {
+    return "P" + (i+1) + " " + (rtt === "-" ? "bot" : rtt + "ms")
+  })
+  document.getElementById("latency").textContent = "Ping: " + pings.join("  ")
+}
+
+// ---------------------------------------------------------------------------
+// Handle BotDecision message - overlay the bot's reasoning on the page
+//    Bot: int
+//    Tile: int
+//    Action: string
+//    Reason: string
+//    Candidates: [int]
+//    Remembered: {tile: value}
+// ---------------------------------------------------------------------------
+
+function showBotDecision(dec) {
+  let overlay = document.querySelector(".botOverlay")
+  let remembered = Object.keys(dec.Remembered || {}).length
+  let line = document.createElement("div")
+  line.textContent = "Bot " + dec.Bot + " " + dec.Action + " flip of tile " + dec.Tile +
+                   ": " + dec.Reason + " (" + (dec.Candidates || []).length +
+                   " candidates, " + remembered + " tiles remembered)"
+  overlay.insertBefore(line, overlay.firstChild)
+  while (overlay.childNodes.length > 5) {
+    overlay.removeChild(overlay.lastChild)
+  }
 }
 
 function sleep(ms) {
@@ -1,20 +1,42 @@
 // ---------------------------------------------------------------------------
-// A two-player tile-turning memory game in real time (not turn-based)
+// A tile-turning memory game for two to six players, in real time or
+// turn-based
 //  - CLient-server architecture
-//  - One, two, or zero computer players (membots)
+//  - Any number of computer players (membots)
 //
 // Files in package:
 //   memory.go - the HTTP server and client socket
-//   gamemanager.go - controls a sequence of two-player games
+//   gamemanager.go - controls a sequence of games
 //   membot.go - implements a computer player with variable ability
+//   botexpert.go - an expert strategy for the computer player
+//   botadapt.go - adjusts a computer player's ability to suit the human
+//   replay.go - records games and their bot decisions, and spectators
+//   clock.go - real and virtual clocks
+//   sim.go - fast simulation of bot-vs-bot games on a virtual clock
+//   report.go - rates bot profiles from a round-robin of simulated games
+//   botproc.go - runs a computer player written in any language as a
+//                child process
+//   fairplay.go - limits how fast any player may flip
+//   arbiter.go - plays near-simultaneous flips in the order they were made
+//   outqueue.go - bounded outbound message queues, one per connection
+//   lobby.go - keeps clients yet to join a game up to date with the games
+//   invite.go - private games, joined with an invite code
+//   matchmaking.go - pairs players who ask for the same game, or finds a bot
+//   waiting.go - fills waiting games with bots, or abandons them
+//   accounts.go - player accounts, logins and session cookies
+//   history.go - records finished games in an SQLite database
+//   ratings.go - rates players after each rated game, and the leaderboard
 // ---------------------------------------------------------------------------
 
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +51,9 @@ import (
 
 const GAME_SLOTS int = 2
 
+const MIN_SEATS int = 2
+const MAX_SEATS int = 6
+
 const GAME_EMPTY int = 0
 const GAME_WAITING int = 1
 const GAME_RUNNING int = 2
@@ -42,27 +67,58 @@ type clientMap_t map[string]client_t
 
 type player_t struct {
 	Name  string
-	Num   int
+	Num   int // Seat number, or 0 for an empty seat
 	IsBot bool
+	Team  int // Team number in a team game, otherwise 0
 
 	// Below not shared with client
 	clientIP string
-	slowPc   int
-	memPc    int
-	move     chan string
-	board    chan string
+	bot      *botProfile_t // Bot abilities, or nil for a human
+	explain  bool          // Send bot decisions to this spectator
+	move     chan string   // Shared by all players of a game
+	board    chan string   // Board messages to a bot
+	link     *link_t       // Connection to a human's client, or nil for a bot
+	queue    *outQueue_t   // Board messages to a human's client
+	account  string        // Account played under, or "" for a guest or bot
 }
 
 type game_t struct {
 	Status      int // Waiting, Running
 	Tmax        int
-	P1          player_t
-	P2          player_t
-	P1won       int
-	P2won       int
+	Seats       int        // Players needed to start, MIN_SEATS to MAX_SEATS
+	Teams       int        // Number of teams, or 0 if every player is for themselves
+	SetSize     int        // Tiles of one value that make a set: 2 for pairs, 3 for triples
+	TurnBased   bool       // Players take turns, rather than racing
+	Private     bool       // Joined only with the invite code
+	Players     []player_t // Player p is Players[p-1]
+	Won         []int      // Games won, by side (index 0 counts ties)
+	Fouls       []int      // Fair-play fouls over all games, by player
 	GameCounter int
 	// Below not shared with client
+	idx         int         // Slot in Games
+	waiting     chan bool   // Closed once the game stops waiting for players
+	invite      string      // Invite code of a private game
+	password    string      // ... and its password, if any
+	moves       chan string // Moves from every player, tagged with the player
 	moveCounter int
+	out         []bool // Players who have forfeited the current game
+	left        []bool // Humans who have left the game for good
+	guzumps     []int  // Guzumps won this game, by player
+	turn        int    // Player whose turn it is, in a turn-based game
+	revealing   bool   // ... whose mismatch is on show before the turn passes
+	hideAfter   time.Duration
+	hideAt      []time.Time // When each player's unmatched tiles are hidden, if set
+	fairPlay    fairPlay_t
+	fouls       []int         // Fair-play fouls this game, by player
+	flips       []int         // Flips played this game, by player
+	lastFlip    []time.Time   // Each player's last flip allowed
+	flipTimes   [][]time.Time // ... and those within the burst window
+	arbitrate   time.Duration // Arbitration window, or 0
+	held        []heldFlip_t  // Flips held for arbitration, in the order made
+	replay      *replay_t
+	bots        sync.WaitGroup // The bots playing the current game
+	watchers    []watcher_t
+	clock       clock_t
 }
 
 type gameTable_t [20]game_t
@@ -71,14 +127,18 @@ type gameTable_t [20]game_t
 // GLOBALS
 // ---------------------------------------------------------------------------
 
-var SessWG sync.WaitGroup = sync.WaitGroup{}
-
 var VerboseGlobal = true
 
 var Games gameTable_t
 
+// Guards the seating of players in Games
+var GamesMu sync.Mutex
+
 var TileFaces map[int]string
 
+// Unmatched tiles left face up are hidden after this long (0 for never)
+var AutoHide time.Duration
+
 // ---------------------------------------------------------------------------
 // Main
 //  - Start an (immortal) webserver. This will serve the game page and images
@@ -89,7 +149,55 @@ var TileFaces map[int]string
 // ---------------------------------------------------------------------------
 
 func main() {
+	procBotCmd := flag.String("procbot", "", "command line of an external bot (see botproc.go)")
+	procBotName := flag.String("procbotname", "PROCBOT", "name of the external bot")
+	simGames := flag.Int("sim", 0, "simulate this many bot-vs-bot games, then exit")
+	simBot1 := flag.Int("simbot1", 1, "bot profile for the first simulated player")
+	simBot2 := flag.Int("simbot2", 2, "bot profile for the second simulated player")
+	simTiles := flag.Int("tiles", 20, "number of tiles in simulated games")
+	simSetSize := flag.Int("setsize", 2, "tiles per set in simulated games (2 for pairs, 3 for triples...)")
+	simSeed := flag.Int64("seed", 1, "seed for the first simulated game")
+	report := flag.String("report", "", "write a round-robin strategy report with this file name, then exit")
+	reportBots := flag.String("reportbots", "1,2,3", "bot profiles in the report")
+	reportTiles := flag.String("reporttiles", "12,20", "board sizes in the report")
+	reportGames := flag.Int("reportgames", 200, "games per pairing per board size in the report")
+	flag.DurationVar(&AutoHide, "autohide", 3*time.Second, "hide unmatched face-up tiles after this long (0 for never)")
+	flag.DurationVar(&FairPlay.minInterval, "minflip", FairPlay.minInterval, "least time between one player's flips")
+	flag.IntVar(&FairPlay.burst, "burst", FairPlay.burst, "most flips by one player in a burst window (0 for no limit)")
+	flag.DurationVar(&FairPlay.burstWindow, "burstwindow", FairPlay.burstWindow, "burst window for the flip limit")
+	flag.DurationVar(&PingInterval, "pinginterval", PingInterval, "how often to ping each client")
+	flag.DurationVar(&PongTimeout, "pongtimeout", PongTimeout, "disconnect a client that does not answer pings for this long")
+	flag.DurationVar(&Arbitrate, "arbitrate", 0, "hold flips this long and play them in the order made, allowing for each player's latency (0 to play them as they arrive)")
+	flag.DurationVar(&MatchWait, "matchwait", MatchWait, "how long a player waits for a match before playing a bot")
+	flag.IntVar(&MatchBot, "matchbot", MatchBot, "bot profile for a player nobody matched, or seats nobody took")
+	flag.DurationVar(&WaitTimeout, "waittimeout", WaitTimeout, "start a game not yet full with bots, or abandon it if nobody has joined, after this long")
+	flag.StringVar(&HistoryFile, "history", HistoryFile, "SQLite database in which finished games are recorded (\"\" to keep none)")
+	flag.Parse()
+
+	if *procBotCmd != "" {
+		BotProfiles = append(BotProfiles, botProfile_t{Name: *procBotName, cmd: *procBotCmd})
+	}
+
+	if *simGames > 0 {
+		runSims(*simGames, *simBot1, *simBot2, *simTiles, *simSetSize, *simSeed)
+		return
+	}
+
+	if *report != "" {
+		runReport(*report, parseInts(*reportBots), parseInts(*reportTiles), *reportGames, *simSeed)
+		return
+	}
+
 	setTileFaces()
+	loadAccounts()
+	loadSessions()
+	if HistoryFile != "" {
+		db, err := openHistory(HistoryFile)
+		if err != nil {
+			log.Fatalln("Cannot open history:", err)
+		}
+		HistoryDB = db
+	}
 
 	HttpsServer(8088)
 	return
@@ -104,6 +212,12 @@ func HttpsServer(port int) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", httpHandleRequest)
 	mux.HandleFunc("/game/", wssGame)
+	mux.HandleFunc("/account/register", httpRegister)
+	mux.HandleFunc("/account/login", httpLogin)
+	mux.HandleFunc("/account/logout", httpLogout)
+	mux.HandleFunc("/account/me", httpMe)
+	mux.HandleFunc("/leaderboard", httpLeaderboardPage)
+	mux.HandleFunc("/leaderboard.json", httpLeaderboard)
 
 	if VerboseGlobal {
 		fmt.Printf("Listening on port %d (%s)...\n", port, serverAddr)
@@ -127,18 +241,46 @@ func httpHandleRequest(w http.ResponseWriter, r *http.Request) {
 
 	if r.URL.Path == "/" {
 		http.ServeFile(w, r, "memgame.html")
+	} else if path, ok := publicFile(r.URL.Path[1:]); ok {
+		http.ServeFile(w, r, path)
 	} else {
-		http.ServeFile(w, r, r.URL.Path[1:])
+		http.NotFound(w, r)
 	}
 }
 
+// The pages the browser may fetch, with their scripts and styles. Beyond
+// these, only the images in STATIC_DIR are served: nothing else in the
+// server's directory is, whatever the case of its name on a filesystem that
+// ignores case (accounts, the TLS key, replays and the history included).
+var PublicFiles = map[string]bool{
+	"memgame.html": true, "memgame.css": true, "memgame.js": true,
+	"memgame1P.html": true, "memgame1P.css": true, "memgame1P.js": true,
+	"leaderboard.html": true, "leaderboard.js": true, "clipboard.html": true,
+}
+
+const STATIC_DIR = "static"
+
+// Returns: the file to serve for a request path, and false if there is none
+func publicFile(path string) (string, bool) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if PublicFiles[path] {
+		return path, true
+	}
+	name, inStatic := strings.CutPrefix(path, STATIC_DIR+"/")
+	if inStatic && name != "" && !strings.Contains(name, "/") {
+		return path, true
+	}
+	return "", false
+}
+
 // ---------------------------------------------------------------------------
-// This function handles a player starting a new game as player 1 or joining
-// a waiting game as player 2:
+// This function handles a player starting a new game in seat 1, joining
+// a waiting game in the next free seat, or spectating a running game:
 //  - Spawned as a goroutine by HTTP server
-//  - If player1 asks for a bot opponent, this function starts the game
-//    immendiately. Otherwise, wait for player2 to join.
-//  - Player2 can also specify a bot to run. TODO
+//  - Player 1 chooses the number of seats, whether players form teams, and
+//    how many of the seats bots fill. Others may choose a team to join.
+//    The game starts as soon as every seat is filled, run by the goroutine
+//    of whoever filled the last seat.
 // ---------------------------------------------------------------------------
 
 func wssGame(w http.ResponseWriter, r *http.Request) {
@@ -152,81 +294,291 @@ func wssGame(w http.ResponseWriter, r *http.Request) {
 	defer wssConn.Close()
 
 	// -------------------------------------------------------------------------
-	// Create the socket I/O channels
+	// Create the socket output queue. Moves go to the game's move channel.
 	// -------------------------------------------------------------------------
 
-	move_chan := make(chan string, 10)  // Socket Reader to Game Manager
-	board_chan := make(chan string, 10) // Game Manager to Socket Writer
+	queue := newOutQueue(func() { wssConn.Close() }) // Game Manager to Socket Writer
 
-	defer close(move_chan)
-	defer close(board_chan)
+	defer queue.close()
 
 	// -------------------------------------------------------------------------
-	// Advise client of all games in progress
+	// Wait (block) in the lobby for a new game request or a join game
+	// request, advising the client of all games in progress as they change
 	// -------------------------------------------------------------------------
 
-	success := SendGamesInProgress(wssConn)
+	lobbyQueue := newOutQueue(func() { wssConn.Close() })
+	joinLobby(lobbyQueue)
+	go socketWriter(wssConn, lobbyQueue, 0)
+	// TODO   - SendBotProfiles(wssConn)
+
+	humanPlayer, ng, success := startOrJoin(wssConn)
+	leaveLobby(lobbyQueue)
+	defer lobbyQueue.close()
+	if !ng.match {
+		lobbyQueue.close()
+		<-lobbyQueue.done
+	}
 	if !success {
-		log.Fatalln("Could not send game table to client")
+		log.Println("Client left the lobby without a good attempt to start or join a game")
+		return
 	}
-	// TODO   - SendBotProfiles(wssConn)
+
+	if humanPlayer.Num == 0 {
+		watchGame(wssConn, &Games[ng.Idx], queue, humanPlayer.explain)
+		return
+	}
+
+	if !humanPlayer.identify(sessionAccount(r)) {
+		log.Println("Guest may not play as", humanPlayer.Name)
+		return
+	}
+	humanPlayer.clientIP = r.RemoteAddr
+	humanPlayer.queue = queue
+	humanPlayer.link = &link_t{}
 
 	// -------------------------------------------------------------------------
-	// Wait (block) for a new game request or a join game request
+	// Create the game, or take a seat in it
 	// -------------------------------------------------------------------------
 
-	humanPlayer, tMax, gameIdx, bot, success := startOrJoin(wssConn)
+	// A player waiting for a match is still read from, so that leaving (or
+	// cancelling) takes them out of the queue. The lobby writer keeps the
+	// heartbeat going until they are seated.
+	var game *game_t
+	var full bool
+	relay := make(chan string, 10)
+	gone := make(chan bool)
+	if ng.match {
+		go func() {
+			socketReader(wssConn, relay, 0, humanPlayer.link)
+			close(gone)
+		}()
+		game, humanPlayer.Num, full, success = findMatch(ng, humanPlayer, gone)
+		lobbyQueue.close()
+		<-lobbyQueue.done
+		if !success {
+			wssConn.Close()
+			go relayMoves(relay, gone, nil, 0)
+		}
+	} else if humanPlayer.Num == 1 {
+		game, full, success = newGame(ng, humanPlayer)
+	} else {
+		game, humanPlayer.Num, full, success = joinGame(ng, humanPlayer)
+	}
 	if !success {
-		log.Fatalln("Bad attempt to start or join a game")
+		log.Println("Game", ng.Idx, "is not open to", humanPlayer.Name)
+		return
+	}
+	if game.Private {
+		queue.push(inviteMsg(game))
+	}
+	if ng.match {
+		queue.push(matchedMsg(game, humanPlayer.Num))
 	}
-
-	humanPlayer.clientIP = r.RemoteAddr
-	humanPlayer.move = move_chan
-	humanPlayer.board = board_chan
 
 	// -------------------------------------------------------------------------
-	// Waitgroup
+	// Socket reader and writer for the rest of the session
 	// -------------------------------------------------------------------------
 
-	SessWG.Add(2)
-
-	go socketReader(wssConn, move_chan, humanPlayer.Num)
-	go socketWriter(wssConn, board_chan, humanPlayer.Num)
+	readerDone := make(chan bool)
+	go func() {
+		if ng.match {
+			relayMoves(relay, gone, game.moves, humanPlayer.Num)
+		} else {
+			socketReader(wssConn, game.moves, humanPlayer.Num, humanPlayer.link)
+		}
+		close(readerDone)
+	}()
+	go socketWriter(wssConn, queue, humanPlayer.Num)
 
 	// -------------------------------------------------------------------------
-	// Create game and players
+	// Start game when every seat is filled
 	// -------------------------------------------------------------------------
 
-	if humanPlayer.Num == 1 {
-		Games[gameIdx] = game_t{GAME_WAITING, tMax, humanPlayer, player_t{}, 0, 0, 0, 0}
+	if full {
+		gameManager(game, VerboseGlobal)
+	}
 
-		if bot > 0 {
-			bot_move_chan := make(chan string, 10)  // Bot to Game Manager
-			bot_board_chan := make(chan string, 10) // Game Manager to Bot
+	// The session lasts until the client leaves
+	<-readerDone
+	game.unseat(humanPlayer.Num, queue)
+	queue.close()
+	<-queue.done
+}
 
-			defer close(bot_move_chan)
-			defer close(bot_board_chan)
+// ---------------------------------------------------------------------------
+// Set up a new game with the human in seat 1 (or humans from seat 1, for a
+// match), and bots in as many of the other seats as were asked for. Bots
+// take the last free seats, or in a team game, the last free seats of the
+// team asked for (if any) first.
+//
+// Returns: the game, whether every seat is now filled, and success
+// ---------------------------------------------------------------------------
+
+func newGame(ng newGame_t, humans ...player_t) (*game_t, bool, bool) {
+	GamesMu.Lock()
+	defer GamesMu.Unlock()
+
+	game := &Games[ng.Idx]
+	if game.Status != GAME_EMPTY {
+		return nil, false, false
+	}
 
-			// TODO - map bot index to slow and mem bot params and name.
-			botPlayer := player_t{"MEMBOT", 2, true, "", 50, 99, bot_move_chan, bot_board_chan}
-			Games[gameIdx].P2 = botPlayer
+	*game = game_t{idx: ng.Idx, waiting: make(chan bool), Status: GAME_WAITING, Tmax: ng.Tmax, Seats: ng.Seats, Teams: ng.Teams, SetSize: ng.SetSize, TurnBased: ng.TurnBased,
+		Private: ng.Private, password: ng.Password,
+		Players: make([]player_t, ng.Seats), Won: make([]int, ng.Seats+1), Fouls: make([]int, ng.Seats+1),
+		moves: make(chan string, 10*MAX_SEATS), hideAfter: AutoHide, fairPlay: FairPlay, arbitrate: Arbitrate,
+		clock: realClock_t{}}
+	if game.Private {
+		game.invite = newInviteCode()
+	}
+
+	for h, human := range humans {
+		game.seat(h+1, human)
+	}
+
+	game.seatBots(ng.Bots, ng.OppBot, ng.BotTeam)
+	game.lobbyUpdate(LOBBY_CREATED)
+
+	if game.Status == GAME_WAITING {
+		fillBot := ng.OppBot
+		if fillBot == 0 {
+			fillBot = defaultBot()
 		}
-	} else {
-		//player2 := player_t{r.RemoteAddr, 2, name, botDelegate, 50, 99, move_chan, board_chan}
-		Games[gameIdx].P2 = humanPlayer
+		go game.awaitPlayers(time.Duration(ng.BotAfter)*time.Second, fillBot)
 	}
 
-	// -------------------------------------------------------------------------
-	// Start game when player 2 is connected
-	// -------------------------------------------------------------------------
+	return game, game.Status == GAME_RUNNING, true
+}
 
-	emptyPlayer := player_t{}
-	if Games[gameIdx].P2 != emptyPlayer {
-		Games[gameIdx].Status = GAME_RUNNING
-		gameManager(&Games[gameIdx], VerboseGlobal)
+// ---------------------------------------------------------------------------
+// Seat up to n bots of profile oppBot. Bots take the last free seats, of the
+// team given first, if not 0. Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) seatBots(n, oppBot, team int) {
+	for b := 0; b < n; b++ {
+		p := game.freeSeat(team, true)
+		if p == 0 {
+			p = game.freeSeat(0, true)
+		}
+		if p == 0 {
+			return
+		}
+
+		bot_board_chan := make(chan string, 10) // Game Manager to Bot
+
+		prof := BotProfiles[oppBot].instance()
+		botPlayer := player_t{prof.Name, 0, true, 0, "", prof, false, nil, bot_board_chan, nil, nil, ""}
+		game.seat(p, botPlayer)
 	}
+}
+
+// ---------------------------------------------------------------------------
+// Free a game's slot for a new game, once everybody has left it or it has
+// been abandoned. Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) free() {
+	if game.Status == GAME_WAITING {
+		close(game.waiting)
+	}
+	game.Status = GAME_EMPTY
+	game.Players = nil
+	game.lobbyUpdate(LOBBY_FREED)
+}
+
+// ---------------------------------------------------------------------------
+// Seat a human in the first free seat of a waiting game, on the team asked
+// for if any. A private game also needs its invite code and password.
+//
+// Returns: the game, the player's number, whether every seat is now filled,
+// and success
+// ---------------------------------------------------------------------------
+
+func joinGame(ng newGame_t, human player_t) (*game_t, int, bool, bool) {
+	GamesMu.Lock()
+	defer GamesMu.Unlock()
+
+	game := &Games[ng.Idx]
+	if game.Status != GAME_WAITING || !game.admits(ng.Code, ng.Password) {
+		return nil, 0, false, false
+	}
+
+	p := game.freeSeat(ng.Team, false)
+	if p == 0 {
+		return nil, 0, false, false
+	}
+	game.seat(p, human)
+	game.lobbyUpdate(LOBBY_JOINED)
+
+	return game, p, game.Status == GAME_RUNNING, true
+}
+
+// ---------------------------------------------------------------------------
+// First (or last) free seat, on the given team if not 0. Returns 0 if none.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) freeSeat(team int, last bool) int {
+	found := 0
+	for p := 1; p <= game.Seats; p++ {
+		if game.Players[p-1].Num != 0 || (team != 0 && game.teamOf(p) != team) {
+			continue
+		}
+		found = p
+		if !last {
+			break
+		}
+	}
+	return found
+}
+
+// ---------------------------------------------------------------------------
+// Put a player in seat p, and start the game once every seat is filled.
+// Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) seat(p int, player player_t) {
+	player.Num = p
+	player.Team = game.teamOf(p)
+	player.move = game.moves
+	game.Players[p-1] = player
+
+	if game.freeSeat(0, false) == 0 {
+		game.Status = GAME_RUNNING
+		close(game.waiting)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Teams take alternate seats: in a 2v2 game, seats 1 and 3 are team 1, and
+// seats 2 and 4 are team 2. Without teams, each player is a side of their
+// own, so results are kept by side: the team, or else the player.
+// ---------------------------------------------------------------------------
+
+func seatTeam(p, teams int) int {
+	if teams == 0 {
+		return 0
+	}
+	return (p-1)%teams + 1
+}
+
+func seatSide(p, teams int) int {
+	if teams == 0 {
+		return p
+	}
+	return seatTeam(p, teams)
+}
+
+func (game *game_t) teamOf(p int) int {
+	return seatTeam(p, game.Teams)
+}
+
+func (game *game_t) sideOf(p int) int {
+	return seatSide(p, game.Teams)
+}
 
-	SessWG.Wait()
+func (game *game_t) teammates(p, q int) bool {
+	return game.Teams != 0 && p != q && game.teamOf(p) == game.teamOf(q)
 }
 
 // ----------------------------------------------------------------------------
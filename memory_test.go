@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// ---------------------------------------------------------------------------
+// Only the pages and the images in STATIC_DIR are served, whatever the case
+// of the name asked for
+// ---------------------------------------------------------------------------
+
+func TestPublicFile(t *testing.T) {
+	tests := []struct {
+		path string
+		file string
+	}{
+		{"memgame.js", "memgame.js"},
+		{"leaderboard.html", "leaderboard.html"},
+		{"static/Bhutto150.png", "static/Bhutto150.png"},
+		{"static/../static/Mao150.png", "static/Mao150.png"},
+		{"Memgame.js", ""},
+		{"static", ""},
+		{"static/", ""},
+		{"static/sub/a.png", ""},
+		{"Static/Bhutto150.png", ""},
+		{"static/../key.pem", ""},
+		{"key.pem", ""},
+		{"KEY.PEM", ""},
+		{"cert.pem", ""},
+		{"accounts.json", ""},
+		{"Accounts.json", ""},
+		{"accounts.json.new", ""},
+		{"history.db", ""},
+		{"History.DB-wal", ""},
+		{"replays/a.json", ""},
+		{"Replays/a.json", ""},
+		{"memory.go", ""},
+		{"../memgame.js", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			file, ok := publicFile(tt.path)
+			if file != tt.file || ok != (tt.file != "") {
+				t.Errorf("%q, %v; want %q", file, ok, tt.file)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// ---------------------------------------------------------------------------
+// Outbound message queues.
+//
+// The game manager must never wait on a client's socket: one slow or
+// sleeping client would freeze the game for everybody. So each connection
+// has its own queue of board messages, which the game manager adds to
+// without blocking, and which the connection's socket writer drains. The
+// queue is bounded:
+//  - a (L)atency report replaces any still waiting, as only the latest
+//    matters
+//  - when the queue is full, a bot (D)ecision or (L)atency report is
+//    dropped, to make room or in place of the new message
+//  - a client so far behind that the queue is full of messages that cannot
+//    be dropped is hopelessly slow: it is disconnected (and a player
+//    forfeits, as for any lost connection)
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+const OUTQ_SIZE = 64
+
+type outQueue_t struct {
+	mu     sync.Mutex
+	msgs   []string
+	ready  chan bool // Wakes the socket writer when messages are waiting
+	closed bool
+	hangUp func()    // Disconnects the client
+	done   chan bool // Closed when the socket writer stops
+}
+
+func newOutQueue(hangUp func()) *outQueue_t {
+	return &outQueue_t{ready: make(chan bool, 1), hangUp: hangUp, done: make(chan bool)}
+}
+
+func droppable(msg string) bool {
+	return msg[0] == 'D' || msg[0] == 'L'
+}
+
+// ---------------------------------------------------------------------------
+// Add a message to the queue. Never blocks.
+// ---------------------------------------------------------------------------
+
+func (q *outQueue_t) push(msg string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+
+	if msg[0] == 'L' {
+		for i, queued := range q.msgs {
+			if queued[0] == 'L' {
+				q.msgs[i] = msg
+				return
+			}
+		}
+	}
+
+	if len(q.msgs) >= OUTQ_SIZE {
+		if droppable(msg) {
+			return
+		}
+		i := 0
+		for i < len(q.msgs) && !droppable(q.msgs[i]) {
+			i++
+		}
+		if i == len(q.msgs) {
+			log.Println("Client is", len(q.msgs), "messages behind - disconnecting")
+			q.closed = true
+			q.msgs = nil
+			q.hangUp()
+			q.wake()
+			return
+		}
+		q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+	}
+
+	q.msgs = append(q.msgs, msg)
+	q.wake()
+}
+
+// ---------------------------------------------------------------------------
+// Take every waiting message, for the socket writer.
+//
+// Returns: the messages, and false once the queue is closed
+// ---------------------------------------------------------------------------
+
+func (q *outQueue_t) take() ([]string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msgs := q.msgs
+	q.msgs = nil
+	return msgs, !q.closed
+}
+
+// ---------------------------------------------------------------------------
+// Close the queue when the connection ends, stopping the socket writer
+// ---------------------------------------------------------------------------
+
+func (q *outQueue_t) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.wake()
+}
+
+func (q *outQueue_t) wake() {
+	select {
+	case q.ready <- true:
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// A full queue of board messages, with a (D)ecision at index drop (-1 for
+// none)
+func fullQueue(drop int) []string {
+	msgs := make([]string, OUTQ_SIZE)
+	for i := range msgs {
+		msgs[i] = fmt.Sprintf("F%03d0011", i)
+	}
+	if drop >= 0 {
+		msgs[drop] = "D{}"
+	}
+	return msgs
+}
+
+// ---------------------------------------------------------------------------
+// Pushing onto the queue: latency reports replace each other, droppable
+// messages make room when it is full, and a client whose queue is full of
+// messages it must have is hung up on
+// ---------------------------------------------------------------------------
+
+func TestOutQueuePush(t *testing.T) {
+	tests := []struct {
+		name   string
+		queued []string
+		msg    string
+		want   []string // The queue after the push, or nil if hung up on
+	}{
+		{"empty", nil, "F0000011", []string{"F0000011"}},
+		{"latency", []string{"L10", "P1"}, "L20", []string{"L20", "P1"}},
+		{"full, droppable", fullQueue(-1), "L10", fullQueue(-1)},
+		{"full, room made", fullQueue(3), "P1", append(slices.Delete(fullQueue(3), 3, 4), "P1")},
+		{"full, hopeless", fullQueue(-1), "P1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hungUp := false
+			q := newOutQueue(func() { hungUp = true })
+			q.msgs = slices.Clone(tt.queued)
+
+			q.push(tt.msg)
+			msgs, open := q.take()
+			if open == (tt.want == nil) || hungUp != (tt.want == nil) {
+				t.Fatalf("open %v, hung up %v; want hung up %v", open, hungUp, tt.want == nil)
+			}
+			if !slices.Equal(msgs, tt.want) {
+				t.Errorf("queue %v, want %v", msgs, tt.want)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A closed queue takes no more messages, and closing it again is harmless
+// ---------------------------------------------------------------------------
+
+func TestOutQueueClose(t *testing.T) {
+	q := newOutQueue(func() { t.Error("hung up on a closed queue") })
+	q.push("P1")
+	q.close()
+	q.close()
+	q.push("P2")
+
+	msgs, open := q.take()
+	if open {
+		t.Error("queue still open")
+	}
+	if !slices.Equal(msgs, []string{"P1"}) {
+		t.Errorf("queue %v, want [P1]", msgs)
+	}
+	select {
+	case <-q.ready:
+	default:
+		t.Error("socket writer not woken")
+	}
+}
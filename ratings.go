@@ -0,0 +1,303 @@
+// ---------------------------------------------------------------------------
+// Player ratings and the leaderboard.
+//
+// Players with an account, and bot profiles, have an Elo rating for each
+// board size, set size and mode (racing or turns), kept in the history
+// database. A game is rated when it is public, between two players rather
+// than teams, and every player in it is an account or a bot, at least one an
+// account. Guests are not rated, having no name that is theirs to keep, and
+// nor are adaptive bots, whose strength changes from game to game. Ratings
+// change in the same transaction that records the game, and the history
+// keeps each rated player's rating before and after.
+//
+// Matchmaking pairs players by their rating for the game they ask for.
+//
+//    GET /leaderboard                the leaderboard page
+//    GET /leaderboard.json           [{Rank, Name, Bot, Tiles, SetSize, Mode,
+//                                      Rating, Games, Wins, Losses, Ties}]
+//        ?tiles=20                   ... of one board size
+//        &setsize=2                  ... of one set size
+//        &mode=race or turns         ... of one mode
+//        &bots=0                     ... without bots
+//        &limit=100                  ... at most this many (MAX_LEADERBOARD)
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const RATING_START = MATCH_RATING
+const RATING_K = 32 // Most a rating can change in one game
+const MAX_LEADERBOARD = 100
+
+const RATED_ACCOUNT = "account"
+const RATED_BOT = "bot"
+
+const MODE_RACE = "race"
+const MODE_TURNS = "turns"
+
+type rating_t struct {
+	Rating float64
+	Games  int
+	Wins   int
+	Losses int
+	Ties   int
+}
+
+// ---------------------------------------------------------------------------
+// Who a player is rated as: their account, or their bot profile. Guests and
+// adaptive bots are not rated.
+// ---------------------------------------------------------------------------
+
+func (player *player_t) ratedAs() (string, string, bool) {
+	if player.account != "" {
+		return RATED_ACCOUNT, player.account, true
+	}
+	if player.IsBot && player.bot != nil && player.bot.adapt == nil {
+		return RATED_BOT, player.bot.Name, true
+	}
+	return "", "", false
+}
+
+// ---------------------------------------------------------------------------
+// Returns: true if the game's result changes its players' ratings
+// ---------------------------------------------------------------------------
+
+func (game *game_t) rated() bool {
+	if HistoryDB == nil || game.Private || game.Teams != 0 || len(game.Players) != 2 {
+		return false
+	}
+	accounts := 0
+	for p := range game.Players {
+		if _, _, ok := game.Players[p].ratedAs(); !ok {
+			return false
+		}
+		if game.Players[p].account != "" {
+			accounts++
+		}
+	}
+	kind1, name1, _ := game.Players[0].ratedAs()
+	kind2, name2, _ := game.Players[1].ratedAs()
+	return accounts > 0 && (kind1 != kind2 || name1 != name2)
+}
+
+// ---------------------------------------------------------------------------
+// A player's rating for this board size, set size and mode, as stored in the
+// history transaction tx, or a starting rating if they have none yet
+// ---------------------------------------------------------------------------
+
+func readRating(tx *sql.Tx, kind, name string, tiles, setSize int, turnBased bool) (rating_t, error) {
+	r := rating_t{Rating: RATING_START}
+	err := tx.QueryRow(`SELECT rating, games, wins, losses, ties FROM ratings
+		WHERE kind = ? AND name = ? AND tiles = ? AND set_size = ? AND turn_based = ?`,
+		kind, name, tiles, setSize, turnBased).
+		Scan(&r.Rating, &r.Games, &r.Wins, &r.Losses, &r.Ties)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return r, err
+}
+
+// ---------------------------------------------------------------------------
+// Rate a two-player game won by player winner (0 if tied), as part of the
+// transaction that records it.
+//
+// Returns: each player's rating before and after, by seat
+// ---------------------------------------------------------------------------
+
+func (game *game_t) rate(tx *sql.Tx, winner int) ([]float64, []float64, error) {
+	ratings := make([]rating_t, 3)
+	for p := 1; p <= 2; p++ {
+		kind, name, _ := game.Players[p-1].ratedAs()
+		r, err := readRating(tx, kind, name, game.Tmax, game.SetSize, game.TurnBased)
+		if err != nil {
+			return nil, nil, err
+		}
+		ratings[p] = r
+	}
+
+	before := []float64{0, ratings[1].Rating, ratings[2].Rating}
+	after := make([]float64, 3)
+	for p := 1; p <= 2; p++ {
+		opp := 3 - p
+		expected := 1 / (1 + math.Pow(10, (before[opp]-before[p])/400))
+		score := 0.5
+		switch winner {
+		case 0:
+			ratings[p].Ties++
+		case p:
+			score = 1
+			ratings[p].Wins++
+		default:
+			score = 0
+			ratings[p].Losses++
+		}
+		ratings[p].Games++
+		after[p] = before[p] + RATING_K*(score-expected)
+		ratings[p].Rating = after[p]
+
+		kind, name, _ := game.Players[p-1].ratedAs()
+		r := ratings[p]
+		_, err := tx.Exec(`INSERT INTO ratings (kind, name, tiles, set_size, turn_based, rating, games, wins, losses,
+				ties, updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (kind, name, tiles, set_size, turn_based) DO UPDATE SET rating = excluded.rating,
+				games = excluded.games, wins = excluded.wins, losses = excluded.losses, ties = excluded.ties,
+				updated = excluded.updated`,
+			kind, name, game.Tmax, game.SetSize, game.TurnBased, r.Rating, r.Games, r.Wins, r.Losses, r.Ties,
+			time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, after, nil
+}
+
+// ---------------------------------------------------------------------------
+// A human's rating for a game of this board size, set size and mode, for
+// matchmaking.
+// Guests, and anyone not yet rated, start at RATING_START.
+// ---------------------------------------------------------------------------
+
+func matchRating(human player_t, key matchKey_t) int {
+	if human.account == "" || HistoryDB == nil {
+		return RATING_START
+	}
+	rating := float64(RATING_START)
+	err := HistoryDB.QueryRow(`SELECT rating FROM ratings
+		WHERE kind = ? AND name = ? AND tiles = ? AND set_size = ? AND turn_based = ?`,
+		RATED_ACCOUNT, human.account, key.tMax, key.setSize, key.turnBased).Scan(&rating)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Rating not read:", err)
+	}
+	return int(math.Round(rating))
+}
+
+// ---------------------------------------------------------------------------
+// The leaderboard, best first
+// ---------------------------------------------------------------------------
+
+type leader_t struct {
+	Rank    int
+	Name    string
+	Bot     bool
+	Tiles   int
+	SetSize int
+	Mode    string
+	Rating  int
+	Games   int
+	Wins    int
+	Losses  int
+	Ties    int
+}
+
+func leaderboard(tiles, setSize int, mode string, bots bool, limit int) ([]leader_t, error) {
+	query := `SELECT kind, name, tiles, set_size, turn_based, rating, games, wins, losses, ties FROM ratings WHERE 1 = 1`
+	args := []any{}
+	if tiles > 0 {
+		query += ` AND tiles = ?`
+		args = append(args, tiles)
+	}
+	if setSize > 0 {
+		query += ` AND set_size = ?`
+		args = append(args, setSize)
+	}
+	if mode != "" {
+		query += ` AND turn_based = ?`
+		args = append(args, mode == MODE_TURNS)
+	}
+	if !bots {
+		query += ` AND kind = ?`
+		args = append(args, RATED_ACCOUNT)
+	}
+	query += ` ORDER BY rating DESC, games DESC, name LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := HistoryDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaders := []leader_t{}
+	for rows.Next() {
+		var kind string
+		var turnBased bool
+		var r rating_t
+		var l leader_t
+		err = rows.Scan(&kind, &l.Name, &l.Tiles, &l.SetSize, &turnBased, &r.Rating, &l.Games, &l.Wins, &l.Losses, &l.Ties)
+		if err != nil {
+			return nil, err
+		}
+		l.Rank = len(leaders) + 1
+		l.Bot = kind == RATED_BOT
+		l.Mode = MODE_RACE
+		if turnBased {
+			l.Mode = MODE_TURNS
+		}
+		l.Rating = int(math.Round(r.Rating))
+		leaders = append(leaders, l)
+	}
+	return leaders, rows.Err()
+}
+
+// ---------------------------------------------------------------------------
+// HTTP handlers
+// ---------------------------------------------------------------------------
+
+func httpLeaderboardPage(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "leaderboard.html")
+}
+
+func httpLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if HistoryDB == nil {
+		http.Error(w, "No games are recorded", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	tiles := 0
+	if t := q.Get("tiles"); t != "" {
+		var err error
+		tiles, err = strconv.Atoi(t)
+		if err != nil || tiles <= 0 {
+			http.Error(w, "Bad tiles", http.StatusBadRequest)
+			return
+		}
+	}
+	setSize := 0
+	if s := q.Get("setsize"); s != "" {
+		var err error
+		setSize, err = strconv.Atoi(s)
+		if err != nil || setSize < MIN_SET_SIZE || setSize > MAX_SET_SIZE {
+			http.Error(w, "Bad set size", http.StatusBadRequest)
+			return
+		}
+	}
+	mode := q.Get("mode")
+	if mode != "" && mode != MODE_RACE && mode != MODE_TURNS {
+		http.Error(w, "Mode is race or turns", http.StatusBadRequest)
+		return
+	}
+	limit := MAX_LEADERBOARD
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 && l < limit {
+		limit = l
+	}
+
+	leaders, err := leaderboard(tiles, setSize, mode, q.Get("bots") != "0", limit)
+	if err != nil {
+		log.Println("Leaderboard:", err)
+		http.Error(w, "Leaderboard not read", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leaders)
+}
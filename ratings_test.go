@@ -0,0 +1,131 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// Which games are rated: public two-player games between accounts and fixed
+// bots, at least one an account
+// ---------------------------------------------------------------------------
+
+func TestRated(t *testing.T) {
+	db, err := openHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	saved := HistoryDB
+	HistoryDB = db
+	defer func() { HistoryDB = saved }()
+
+	ann := player_t{Name: "Ann", account: "ann"}
+	bob := player_t{Name: "Bob", account: "bob"}
+	guest := player_t{Name: "Guest"}
+	membot := player_t{Name: "MEMBOT", IsBot: true, bot: &BotProfiles[1]}
+	adaptive := player_t{Name: "ADAPTIVE", IsBot: true, bot: &BotProfiles[3]}
+	expert := player_t{Name: "EXPERT", IsBot: true, bot: &BotProfiles[2]}
+
+	tests := []struct {
+		name    string
+		players []player_t
+		private bool
+		teams   int
+		rated   bool
+	}{
+		{"accounts", []player_t{ann, bob}, false, 0, true},
+		{"account and bot", []player_t{ann, membot}, false, 0, true},
+		{"adaptive bot", []player_t{ann, adaptive}, false, 0, false},
+		{"guest", []player_t{ann, guest}, false, 0, false},
+		{"bots only", []player_t{membot, expert}, false, 0, false},
+		{"same account", []player_t{ann, ann}, false, 0, false},
+		{"private", []player_t{ann, bob}, true, 0, false},
+		{"three players", []player_t{ann, bob, membot}, false, 0, false},
+		{"teams", []player_t{ann, bob}, false, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game := &game_t{Players: tt.players, Private: tt.private, Teams: tt.teams}
+			if rated := game.rated(); rated != tt.rated {
+				t.Errorf("rated %v, want %v", rated, tt.rated)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A rated game moves its players' ratings for its own board size, set size
+// and mode only
+// ---------------------------------------------------------------------------
+
+func TestRateBySetSize(t *testing.T) {
+	db, err := openHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	saved := HistoryDB
+	HistoryDB = db
+	defer func() { HistoryDB = saved }()
+
+	ann := player_t{Name: "Ann", Num: 1, account: "ann"}
+	bob := player_t{Name: "Bob", Num: 2, account: "bob"}
+	game := &game_t{Players: []player_t{ann, bob}, Tmax: 24, SetSize: 3}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, after, err := game.rate(tx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before[1] != RATING_START || before[2] != RATING_START {
+		t.Errorf("ratings before %v, want %d each", before[1:], RATING_START)
+	}
+	if after[1] != RATING_START+RATING_K/2 || after[2] != RATING_START-RATING_K/2 {
+		t.Errorf("ratings after %v, want %d and %d", after[1:], RATING_START+RATING_K/2, RATING_START-RATING_K/2)
+	}
+
+	tests := []struct {
+		name   string
+		human  player_t
+		key    matchKey_t
+		rating int
+	}{
+		{"winner", ann, matchKey_t{tMax: 24, setSize: 3}, RATING_START + RATING_K/2},
+		{"loser", bob, matchKey_t{tMax: 24, setSize: 3}, RATING_START - RATING_K/2},
+		{"other set size", ann, matchKey_t{tMax: 24, setSize: 2}, RATING_START},
+		{"other board", ann, matchKey_t{tMax: 20, setSize: 3}, RATING_START},
+		{"other mode", ann, matchKey_t{tMax: 24, setSize: 3, turnBased: true}, RATING_START},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rating := matchRating(tt.human, tt.key); rating != tt.rating {
+				t.Errorf("rating %d, want %d", rating, tt.rating)
+			}
+		})
+	}
+
+	leaders, err := leaderboard(24, 2, "", true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaders) != 0 {
+		t.Errorf("%d leaders with set size 2, want none", len(leaders))
+	}
+	leaders, err = leaderboard(24, 3, "", true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaders) != 2 || leaders[0].Name != "ann" || leaders[0].SetSize != 3 {
+		t.Errorf("leaders %+v, want ann then bob with set size 3", leaders)
+	}
+}
@@ -0,0 +1,285 @@
+// ---------------------------------------------------------------------------
+// Game replays and spectators.
+//
+// Every change to the board (as seen by a spectator) and every bot decision
+// is recorded with its time into the game. When a game finishes, the replay is
+// saved as JSON in REPLAY_DIR.
+//
+// Spectators (watchers) receive the board messages that go to a player who
+// did not make the move, and, if they asked for explanations, each bot
+// decision as it is made. A spectator is first sent the board as it stands,
+// and again at the start of each game in the slot. Each event is recorded
+// and sent to spectators in one step, so a new spectator sees it once,
+// either in the board they are sent or as it happens.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const REPLAY_DIR = "replays"
+
+// ---------------------------------------------------------------------------
+// A bot's explanation of one decision: what it chose and why, which tiles it
+// considered, and what it remembered of the face-down tiles at the time.
+// ---------------------------------------------------------------------------
+
+type botDecision_t struct {
+	Bot        int
+	Tile       int
+	Action     string
+	Reason     string
+	Candidates []int       `json:",omitempty"`
+	Remembered map[int]int `json:",omitempty"` // Tile to value
+}
+
+type replayEvent_t struct {
+	Ms       int64
+	Msg      string         `json:",omitempty"`
+	Decision *botDecision_t `json:",omitempty"`
+}
+
+type replay_t struct {
+	Started time.Time
+	Tmax    int
+	Seed    int64 // Seed that dealt the board
+	Players []string
+	Teams   int   // Number of teams, or 0
+	SetSize int   // Tiles of one value that make a set
+	Turns   bool  // Turn-based game
+	Values  []int // Tile values in board order
+	Events  []replayEvent_t
+	Winner  int   // Player, or team in a team game (0 if tied)
+	Fouls   []int // Fair-play fouls, by player
+
+	clock clock_t
+}
+
+type watcher_t struct {
+	queue   *outQueue_t
+	explain bool
+}
+
+// Guards the watcher lists of all games
+var WatchMu sync.Mutex
+
+// ---------------------------------------------------------------------------
+// Start a new replay for a game that is about to be played on this board
+// ---------------------------------------------------------------------------
+
+func newReplay(game *game_t, board tilearray_t, seed int64) *replay_t {
+	r := replay_t{game.clock.Now(), game.Tmax, seed, []string{}, game.Teams, game.SetSize, game.TurnBased, make([]int, len(board)), nil, 0, nil, game.clock}
+	for _, player := range game.Players {
+		r.Players = append(r.Players, player.Name)
+	}
+	for t, tile := range board {
+		r.Values[t] = tile.val
+	}
+	return &r
+}
+
+func (r *replay_t) addEvent(msg string) {
+	r.Events = append(r.Events, replayEvent_t{r.clock.Now().Sub(r.Started).Milliseconds(), msg, nil})
+}
+
+func (r *replay_t) addDecision(dec *botDecision_t) {
+	r.Events = append(r.Events, replayEvent_t{r.clock.Now().Sub(r.Started).Milliseconds(), "", dec})
+}
+
+// ---------------------------------------------------------------------------
+// Write the replay to REPLAY_DIR. Failure is logged but does not stop play.
+// ---------------------------------------------------------------------------
+
+func (r *replay_t) save(gameNum int) {
+	err := os.MkdirAll(REPLAY_DIR, 0755)
+	if err != nil {
+		log.Println("Replay not saved:", err)
+		return
+	}
+
+	replayJson, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		log.Println("Replay not saved:", err)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.json", r.Started.Format("20060102-150405"), strings.Join(r.Players, "-vs-"), gameNum)
+	path := filepath.Join(REPLAY_DIR, filepath.Base(name))
+	err = os.WriteFile(path, replayJson, 0644)
+	if err != nil {
+		log.Println("Replay not saved:", err)
+		return
+	}
+	if VerboseGlobal {
+		log.Println("Replay saved to", path)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// The board so far, as the messages a spectator is sent: the game, then the
+// tiles face up (as if flipped by an opponent), the sets won, and whose turn
+// it is
+// ---------------------------------------------------------------------------
+
+func (r *replay_t) boardMsgs() []string {
+	type watching_t struct {
+		Type    string
+		Tmax    int
+		Players []string
+		Turns   bool
+	}
+	msgJson, err := json.Marshal(watching_t{"Watching", r.Tmax, r.Players, r.Turns})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	up := make([]string, r.Tmax)
+	won := []string{}
+	turn := ""
+	for _, event := range r.Events {
+		msg := event.Msg
+		if msg == "" {
+			continue
+		}
+		switch msg[0] {
+		case 'F', 'O', 'T':
+			idx, _ := strconv.Atoi(msg[1:4])
+			up[idx] = "O" + msg[1:]
+		case 'H', 'R':
+			tiles, _ := msgTiles(msg)
+			for _, idx := range tiles {
+				up[idx] = ""
+			}
+			if msg[0] == 'R' {
+				won = append(won, msg)
+			}
+		case 'P':
+			turn = msg
+		}
+	}
+
+	msgs := []string{string(msgJson)}
+	for _, msg := range up {
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	msgs = append(msgs, won...)
+	if turn != "" {
+		msgs = append(msgs, turn)
+	}
+	return msgs
+}
+
+// ---------------------------------------------------------------------------
+// Add and remove spectators of a game. A new spectator is sent the board of
+// the game in play, if any.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) addWatcher(queue *outQueue_t, explain bool) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	if game.replay != nil {
+		for _, msg := range game.replay.boardMsgs() {
+			queue.push(msg)
+		}
+	}
+	game.watchers = append(game.watchers, watcher_t{queue, explain})
+}
+
+func (game *game_t) removeWatcher(queue *outQueue_t) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	for w, watcher := range game.watchers {
+		if watcher.queue == queue {
+			game.watchers = append(game.watchers[:w], game.watchers[w+1:]...)
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Start the replay of a new game, and show spectators its board
+// ---------------------------------------------------------------------------
+
+func (game *game_t) startReplay(r *replay_t) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	game.replay = r
+	for _, msg := range r.boardMsgs() {
+		game.pushWatchers(msg, false)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Record a board message, or a bot's decision, and send it to spectators
+// ---------------------------------------------------------------------------
+
+func (game *game_t) watchEvent(msg string) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	game.replay.addEvent(msg)
+	game.pushWatchers(msg, false)
+}
+
+func (game *game_t) watchDecision(dec *botDecision_t, msg string) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	game.replay.addDecision(dec)
+	game.pushWatchers(msg, true)
+}
+
+// ---------------------------------------------------------------------------
+// Send a message to spectators. Like a player's, a spectator's messages are
+// queued, so one that is not keeping up never holds up the game.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) sendWatchers(msg string, decision bool) {
+	WatchMu.Lock()
+	defer WatchMu.Unlock()
+	game.pushWatchers(msg, decision)
+}
+
+// Called with WatchMu held
+func (game *game_t) pushWatchers(msg string, decision bool) {
+	for _, watcher := range game.watchers {
+		if decision && !watcher.explain {
+			continue
+		}
+		watcher.queue.push(msg)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Spectate a running game until the spectator's socket closes
+// ---------------------------------------------------------------------------
+
+func watchGame(conn *websocket.Conn, game *game_t, queue *outQueue_t, explain bool) {
+	game.addWatcher(queue, explain)
+	defer game.removeWatcher(queue)
+
+	go socketWriter(conn, queue, 0)
+
+	// A spectator that stops answering pings is dropped
+	link := &link_t{}
+	link.keepAlive(conn)
+
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// A spectator who starts watching mid-game is sent the board as it stands
+// ---------------------------------------------------------------------------
+
+func TestBoardMsgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		turns bool
+		flips [][2]int
+		msgs  []string
+	}{
+		{"new game", false, nil, nil},
+		{"tile face up", false, [][2]int{{1, 0}},
+			[]string{"O0000011"}},
+		{"mismatch hidden by the next flip", false, [][2]int{{1, 0}, {1, 2}, {1, 4}},
+			[]string{"O0040031"}},
+		{"set won", false, [][2]int{{1, 0}, {1, 1}, {2, 2}},
+			[]string{"O0020022", "R0010001"}},
+		{"guzump", false, [][2]int{{1, 0}, {2, 1}},
+			[]string{"R0010002"}},
+		{"whose turn", true, [][2]int{{1, 0}},
+			[]string{"O0000011", "P1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, board := testGame(2, 0, 2, []int{1, 1, 2, 2, 3, 3})
+			game.TurnBased = tt.turns
+			game.replay = newReplay(game, board, 1)
+			if tt.turns {
+				startTurn(game, 1)
+			}
+			for _, flip := range tt.flips {
+				flipTile(game, flip[0], flip[1], board)
+			}
+
+			msgs := game.replay.boardMsgs()
+			if len(msgs) == 0 || msgs[0] != `{"Type":"Watching","Tmax":6,"Players":["",""],"Turns":`+
+				map[bool]string{false: "false", true: "true"}[tt.turns]+`}` {
+				t.Fatalf("first message %v", msgs)
+			}
+			got := msgs[1:]
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, tt.msgs) {
+				t.Errorf("board %q, want %q", got, tt.msgs)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A spectator sees each event once: in the board they are sent on arrival,
+// or as it happens
+// ---------------------------------------------------------------------------
+
+func TestWatcherSeesEachEventOnce(t *testing.T) {
+	game, board := testGame(2, 0, 2, []int{1, 1, 2, 2})
+	flipTile(game, 1, 0, board)
+
+	queue := newOutQueue(func() {})
+	game.addWatcher(queue, false)
+	flipTile(game, 2, 2, board)
+
+	msgs, _ := queue.take()
+	want := []string{`{"Type":"Watching","Tmax":4,"Players":["",""],"Turns":false}`, "O0000011", "O0020022"}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("spectator sent %q, want %q", msgs, want)
+	}
+}
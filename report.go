@@ -0,0 +1,334 @@
+// ---------------------------------------------------------------------------
+// Strategy evaluation report.
+//
+// Plays a round-robin of simulated games between bot profiles, over a range
+// of board sizes and seeds, swapping seats every game. The results are rated
+// with a Bradley-Terry model (shown on the Elo scale, with 95% confidence
+// intervals), each pairing is tested for a significant difference from an
+// even match, and game length and guzumps (deliberate and by chance) are summarised.
+//
+// Written as <name>.md, <name>-ratings.csv and <name>-pairings.csv.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const REPORT_BT_ITERATIONS = 500
+const REPORT_Z95 = 1.96
+
+// Results for one profile across all its games
+type profileStats_t struct {
+	Games       int
+	Wins        int
+	Ties        int
+	Flips       int
+	Duration    time.Duration
+	GuzumpTries int
+	Guzumps     int
+	GuzumpsWon  int
+	Elo         float64
+	EloCI       float64 // Half-width of the 95% interval
+}
+
+// Results for one pairing on one board size, from the first profile's side
+type pairingStats_t struct {
+	A, B   int
+	Tiles  int
+	Games  int
+	Score  float64 // Wins plus half of ties
+	Lo, Hi float64 // 95% interval of the score fraction
+	PValue float64 // Two-sided, against an even match
+}
+
+// ---------------------------------------------------------------------------
+// Play the round-robin and write the report
+// ---------------------------------------------------------------------------
+
+func runReport(name string, bots []int, tileSizes []int, gamesPerPair int, seed int64) {
+	for _, b := range bots {
+		if b <= 0 || b >= len(BotProfiles) || BotProfiles[b].cmd != "" {
+			log.Fatalln("Report needs memBot profiles, not", b)
+		}
+	}
+	if len(bots) < 2 || len(tileSizes) == 0 || gamesPerPair <= 0 {
+		log.Fatalln("Report needs at least two bots, one board size and one game")
+	}
+
+	VerboseGlobal = false
+	started := time.Now()
+
+	n := len(bots)
+	stats := make([]profileStats_t, n)
+	score := make([][]float64, n) // score[i][j]: i's wins plus half ties against j
+	games := make([][]int, n)
+	for i := range score {
+		score[i] = make([]float64, n)
+		games[i] = make([]int, n)
+	}
+	pairings := []pairingStats_t{}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			for _, tMax := range tileSizes {
+				ps := pairingStats_t{A: bots[i], B: bots[j], Tiles: tMax}
+
+				for g := 0; g < gamesPerPair; g++ {
+					gameSeed := seed + int64(g)
+					// seat[1] and seat[2] are indexes into bots
+					seat := [3]int{0, i, j}
+					if g%2 == 1 {
+						seat = [3]int{0, j, i}
+					}
+					r := simGame(BotProfiles[bots[seat[1]]].instance(), BotProfiles[bots[seat[2]]].instance(), tMax, 2, gameSeed)
+
+					for p := 1; p <= 2; p++ {
+						st := &stats[seat[p]]
+						st.Games++
+						st.Flips += r.Flips
+						st.Duration += r.Duration
+						st.GuzumpTries += r.GuzumpTries[p]
+						st.Guzumps += r.Guzumps[p]
+						st.GuzumpsWon += r.GuzumpsWon[p]
+						if r.Winner == p {
+							st.Wins++
+							score[seat[p]][seat[3-p]] += 1
+						} else if r.Winner == 0 {
+							st.Ties++
+							score[seat[p]][seat[3-p]] += 0.5
+						}
+						games[seat[p]][seat[3-p]]++
+					}
+
+					if r.Winner != 0 && seat[r.Winner] == i {
+						ps.Score += 1
+					} else if r.Winner == 0 {
+						ps.Score += 0.5
+					}
+					ps.Games++
+				}
+
+				ps.Lo, ps.Hi = wilsonInterval(ps.Score, ps.Games)
+				z := (ps.Score/float64(ps.Games) - 0.5) / math.Sqrt(0.25/float64(ps.Games))
+				ps.PValue = math.Erfc(math.Abs(z) / math.Sqrt2)
+				pairings = append(pairings, ps)
+			}
+		}
+	}
+
+	bradleyTerry(score, games, stats)
+
+	writeReportMarkdown(name+".md", bots, tileSizes, gamesPerPair, seed, stats, pairings, time.Since(started))
+	writeReportCsv(name, bots, stats, pairings)
+	fmt.Printf("Report of %d games written to %s.md (%s)\n", countGames(stats), name, time.Since(started).Round(time.Millisecond))
+}
+
+func countGames(stats []profileStats_t) int {
+	total := 0
+	for _, st := range stats {
+		total += st.Games
+	}
+	return total / 2
+}
+
+// ---------------------------------------------------------------------------
+// Fit Bradley-Terry strengths by the MM algorithm, and convert them to Elo
+// ratings averaging 1500. Each profile is given one virtual tie against each
+// opponent, so that a profile that never wins still has a finite rating.
+// Confidence intervals come from the Fisher information of each rating.
+// ---------------------------------------------------------------------------
+
+func bradleyTerry(score [][]float64, games [][]int, stats []profileStats_t) {
+	n := len(score)
+	strength := make([]float64, n)
+	for i := range strength {
+		strength[i] = 1
+	}
+
+	for iter := 0; iter < REPORT_BT_ITERATIONS; iter++ {
+		next := make([]float64, n)
+		logSum := 0.0
+		for i := 0; i < n; i++ {
+			won := 0.0
+			denom := 0.0
+			for j := 0; j < n; j++ {
+				if i == j {
+					continue
+				}
+				nij := float64(games[i][j]) + 1
+				won += score[i][j] + 0.5
+				denom += nij / (strength[i] + strength[j])
+			}
+			next[i] = won / denom
+			logSum += math.Log(next[i])
+		}
+		// Normalise to a geometric mean of one
+		scale := math.Exp(logSum / float64(n))
+		for i := range next {
+			strength[i] = next[i] / scale
+		}
+	}
+
+	eloPerLog := 400 / math.Ln10
+	for i := 0; i < n; i++ {
+		info := 0.0
+		for j := 0; j < n; j++ {
+			if i == j {
+				continue
+			}
+			pij := strength[i] / (strength[i] + strength[j])
+			info += (float64(games[i][j]) + 1) * pij * (1 - pij)
+		}
+		stats[i].Elo = 1500 + eloPerLog*math.Log(strength[i])
+		stats[i].EloCI = REPORT_Z95 * eloPerLog / math.Sqrt(info)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Wilson score interval (95%) for a fraction of n games
+// ---------------------------------------------------------------------------
+
+func wilsonInterval(score float64, n int) (float64, float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	z := REPORT_Z95
+	nf := float64(n)
+	p := score / nf
+	centre := (p + z*z/(2*nf)) / (1 + z*z/nf)
+	half := z * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf)) / (1 + z*z/nf)
+	return centre - half, centre + half
+}
+
+func pc(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+func avgSeconds(d time.Duration, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return d.Seconds() / float64(n)
+}
+
+// ---------------------------------------------------------------------------
+// Markdown report
+// ---------------------------------------------------------------------------
+
+func writeReportMarkdown(path string, bots, tileSizes []int, gamesPerPair int, seed int64,
+	stats []profileStats_t, pairings []pairingStats_t, took time.Duration) {
+
+	var md strings.Builder
+
+	fmt.Fprintf(&md, "# Bot strategy report\n\n")
+	fmt.Fprintf(&md, "%d games per pairing per board size; board sizes %s; seeds from %d; %d games in all (%s).\n\n",
+		gamesPerPair, joinInts(tileSizes), seed, countGames(stats), took.Round(time.Millisecond))
+
+	fmt.Fprintf(&md, "## Ratings\n\n")
+	fmt.Fprintf(&md, "| Profile | Elo | 95%% CI | Games | Won %% | Tied %% | Avg game (s) | Avg flips | Guzump tries | Guzump success %% | Guzumps won |\n")
+	fmt.Fprintf(&md, "|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for i, b := range bots {
+		st := stats[i]
+		fmt.Fprintf(&md, "| %s | %.0f | ±%.0f | %d | %.1f | %.1f | %.1f | %.1f | %d | %.1f | %d |\n",
+			BotProfiles[b].Name, st.Elo, st.EloCI, st.Games, pc(st.Wins, st.Games), pc(st.Ties, st.Games),
+			avgSeconds(st.Duration, st.Games), float64(st.Flips)/float64(st.Games),
+			st.GuzumpTries, pc(st.Guzumps, st.GuzumpTries), st.GuzumpsWon)
+	}
+
+	fmt.Fprintf(&md, "\n## Pairings\n\n")
+	fmt.Fprintf(&md, "Score is the first profile's wins plus half its ties. A pairing is significant if p < 0.05.\n\n")
+	fmt.Fprintf(&md, "| Profile A | Profile B | Tiles | Games | A score %% | 95%% CI | p-value | Significant |\n")
+	fmt.Fprintf(&md, "|---|---|---:|---:|---:|---:|---:|---|\n")
+	for _, ps := range pairings {
+		sig := "no"
+		if ps.PValue < 0.05 {
+			sig = "yes"
+		}
+		fmt.Fprintf(&md, "| %s | %s | %d | %d | %.1f | %.1f–%.1f | %.3g | %s |\n",
+			BotProfiles[ps.A].Name, BotProfiles[ps.B].Name, ps.Tiles, ps.Games,
+			100*ps.Score/float64(ps.Games), 100*ps.Lo, 100*ps.Hi, ps.PValue, sig)
+	}
+
+	err := os.WriteFile(path, []byte(md.String()), 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// CSV reports, one for ratings and one for pairings
+// ---------------------------------------------------------------------------
+
+func writeReportCsv(name string, bots []int, stats []profileStats_t, pairings []pairingStats_t) {
+	ratings := [][]string{{"profile", "elo", "elo_ci95", "games", "wins", "ties",
+		"avg_game_s", "avg_flips", "guzump_tries", "guzumps", "guzumps_won"}}
+	for i, b := range bots {
+		st := stats[i]
+		ratings = append(ratings, []string{BotProfiles[b].Name,
+			fmt.Sprintf("%.1f", st.Elo), fmt.Sprintf("%.1f", st.EloCI),
+			strconv.Itoa(st.Games), strconv.Itoa(st.Wins), strconv.Itoa(st.Ties),
+			fmt.Sprintf("%.3f", avgSeconds(st.Duration, st.Games)),
+			fmt.Sprintf("%.2f", float64(st.Flips)/float64(st.Games)),
+			strconv.Itoa(st.GuzumpTries), strconv.Itoa(st.Guzumps), strconv.Itoa(st.GuzumpsWon)})
+	}
+	writeCsvFile(name+"-ratings.csv", ratings)
+
+	rows := [][]string{{"profile_a", "profile_b", "tiles", "games", "a_score", "a_score_lo95", "a_score_hi95", "p_value"}}
+	for _, ps := range pairings {
+		rows = append(rows, []string{BotProfiles[ps.A].Name, BotProfiles[ps.B].Name,
+			strconv.Itoa(ps.Tiles), strconv.Itoa(ps.Games),
+			fmt.Sprintf("%.1f", ps.Score), fmt.Sprintf("%.4f", ps.Lo), fmt.Sprintf("%.4f", ps.Hi),
+			fmt.Sprintf("%.4g", ps.PValue)})
+	}
+	writeCsvFile(name+"-pairings.csv", rows)
+}
+
+func writeCsvFile(path string, rows [][]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+	if err = w.Error(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Comma-separated integer lists, for command-line flags
+// ---------------------------------------------------------------------------
+
+func parseInts(list string) []int {
+	ints := []int{}
+	for _, field := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			log.Fatalln("Bad number in list:", list)
+		}
+		ints = append(ints, v)
+	}
+	return ints
+}
+
+func joinInts(ints []int) string {
+	strs := make([]string, len(ints))
+	for i, v := range ints {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,190 @@
+// ---------------------------------------------------------------------------
+// Fast simulation of bot-vs-bot games.
+//
+// A simulated game runs the real game rules (flipTile) and the real bots
+// (memBot_t) on a virtual clock, in a single goroutine. Rather than sleeping,
+// the simulator repeatedly advances the clock to whichever bot is due to act
+// next, lets it flip, and delivers the resulting board updates to both bots.
+// Unmatched tiles are hidden on the same clock, when their time is up.
+// Bots therefore act in exactly the order their reaction times dictate,
+// guzump races included, but a game takes microseconds.
+//
+// Only memBot profiles can be simulated; external (procBot) bots run in
+// real time.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+type simResult_t struct {
+	Winner      int
+	Pairs       [3]int // Sets won by players 1 and 2
+	GuzumpTries [3]int // Guzumps planned by players 1 and 2, won or lost
+	Guzumps     [3]int // ... and won by those attempts
+	GuzumpsWon  [3]int // All guzumps won, deliberate or by chance
+	Fouls       [3]int // Flips refused by the fair-play rules
+	Flips       int
+	Duration    time.Duration // Virtual time the game took
+}
+
+// ---------------------------------------------------------------------------
+// Play one game between two bot profiles. The seed decides the board and all
+// of the bots' random choices, so a game can be replayed exactly.
+// ---------------------------------------------------------------------------
+
+func simGame(prof1, prof2 *botProfile_t, tMax, setSize int, seed int64) simResult_t {
+	var result simResult_t
+
+	rng := rand.New(rand.NewSource(seed))
+	clock := &virtualClock_t{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	// Board channels are big enough to hold every update from one flip
+	game := game_t{Status: GAME_RUNNING, Tmax: tMax, Seats: 2, SetSize: setSize, hideAfter: AutoHide, fairPlay: FairPlay, clock: clock}
+	game.Players = []player_t{
+		{Name: prof1.Name, Num: 1, IsBot: true, bot: prof1, board: make(chan string, 10)},
+		{Name: prof2.Name, Num: 2, IsBot: true, bot: prof2, board: make(chan string, 10)}}
+	game.clearScores()
+
+	board := make(tilearray_t, tMax)
+	initBoard(tMax, setSize, board, rng)
+	game.replay = newReplay(&game, board, seed)
+
+	players := []*player_t{nil, &game.Players[0], &game.Players[1]}
+	bots := []*memBot_t{nil,
+		newMemBot(game.Players[0], game.rules(), false, clock, rng.Int63()),
+		newMemBot(game.Players[1], game.rules(), false, clock, rng.Int63())}
+	done := []bool{false, bots[1].think(), bots[2].think()}
+
+	// A guzump is tried as soon as a bot plans one, whether or not it wins
+	// the race: the opponent may complete the set first
+	planned := []int{-1, -1, -1}
+	countTry := func(p int) {
+		if bots[p].plan_act != ACT_GUZUMP {
+			planned[p] = -1
+		} else if bots[p].plan_idx != planned[p] {
+			planned[p] = bots[p].plan_idx
+			result.GuzumpTries[p]++
+		}
+	}
+	countTry(1)
+	countTry(2)
+
+	start := clock.Now()
+
+	for !isGameFinished(board) {
+		// Choose the bot due to act next. A tie is settled at random, as
+		// the game manager's select would.
+		next := 0
+		for p := 1; p <= 2; p++ {
+			if done[p] {
+				continue
+			}
+			if next == 0 || bots[p].wakeAt.Before(bots[next].wakeAt) ||
+				(bots[p].wakeAt.Equal(bots[next].wakeAt) && rng.Intn(2) == 0) {
+				next = p
+			}
+		}
+		hideAt := game.nextHide()
+		if next == 0 && hideAt.IsZero() {
+			break
+		}
+
+		if !hideAt.IsZero() && (next == 0 || hideAt.Before(bots[next].wakeAt)) {
+			clock.advanceTo(hideAt)
+			autoHide(&game, board)
+		} else {
+			clock.advanceTo(bots[next].wakeAt)
+			tile_idx, dec := bots[next].wake()
+			if tile_idx >= 0 {
+				if reason := game.checkFlip(next); reason != "" {
+					players[next].board <- "E" + reason
+				} else {
+					guzumped := game.guzumps[next]
+					flipTile(&game, next, tile_idx, board)
+					result.Flips++
+					if dec.Action == ACT_NAMES[ACT_GUZUMP] && game.guzumps[next] > guzumped {
+						result.Guzumps[next]++
+					}
+				}
+			}
+			done[next] = bots[next].think()
+			countTry(next)
+		}
+
+		// Deliver the board updates, letting each bot think after each one
+		for p := 1; p <= 2; p++ {
+		deliver_loop:
+			for {
+				select {
+				case b := <-players[p].board:
+					bots[p].onBoard(b)
+					done[p] = bots[p].think()
+					countTry(p)
+				default:
+					break deliver_loop
+				}
+			}
+		}
+	}
+
+	result.Winner = game.winner(board)
+	copy(result.Pairs[:], gameScores(board, 2, setSize))
+	copy(result.GuzumpsWon[:], game.guzumps)
+	copy(result.Fouls[:], game.fouls)
+	result.Duration = clock.Now().Sub(start)
+	return result
+}
+
+// ---------------------------------------------------------------------------
+// Play a run of simulated games between two bot profiles (by index into
+// BotProfiles), swapping seats each game, and print a summary.
+// ---------------------------------------------------------------------------
+
+func runSims(games, bot1, bot2, tMax, setSize int, seed int64) {
+	if bot1 <= 0 || bot1 >= len(BotProfiles) || bot2 <= 0 || bot2 >= len(BotProfiles) ||
+		BotProfiles[bot1].cmd != "" || BotProfiles[bot2].cmd != "" {
+		log.Fatalln("Simulation needs two memBot profiles")
+	}
+	if setSize < MIN_SET_SIZE || setSize > MAX_SET_SIZE || tMax%setSize != 0 {
+		log.Fatalln("Simulation needs a set size of", MIN_SET_SIZE, "to", MAX_SET_SIZE, "that divides the tiles")
+	}
+
+	VerboseGlobal = false
+	wins := [3]int{}
+	var duration time.Duration
+	flips := 0
+	fouls := 0
+	started := time.Now()
+
+	for g := 0; g < games; g++ {
+		prof1 := BotProfiles[bot1].instance()
+		prof2 := BotProfiles[bot2].instance()
+
+		// Swap seats on odd games; count wins by profile
+		var r simResult_t
+		if g%2 == 0 {
+			r = simGame(prof1, prof2, tMax, setSize, seed+int64(g))
+			wins[r.Winner]++
+		} else {
+			r = simGame(prof2, prof1, tMax, setSize, seed+int64(g))
+			wins[(3-r.Winner)%3]++
+		}
+		duration += r.Duration
+		flips += r.Flips
+		fouls += r.Fouls[1] + r.Fouls[2]
+	}
+
+	fmt.Printf("%d games of %d tiles, sets of %d, in %s\n", games, tMax, setSize, time.Since(started).Round(time.Millisecond))
+	fmt.Printf("  %-10s won %d\n", BotProfiles[bot1].Name, wins[1])
+	fmt.Printf("  %-10s won %d\n", BotProfiles[bot2].Name, wins[2])
+	fmt.Printf("  tied           %d\n", wins[0])
+	fmt.Printf("  average game   %s, %d flips\n",
+		(duration / time.Duration(games)).Round(time.Millisecond), flips/games)
+	fmt.Printf("  fair-play fouls %d\n", fouls)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// ---------------------------------------------------------------------------
+// A guzump is a race: tries are counted when planned, so some are lost to an
+// opponent who completes the set first, and none is won without a try
+// ---------------------------------------------------------------------------
+
+func TestGuzumpSuccess(t *testing.T) {
+	tests := []struct {
+		name string
+		bot1 int
+		bot2 int
+	}{
+		{"membots", 1, 1},
+		{"experts", 2, 2},
+		{"membot and expert", 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			VerboseGlobal = false
+			tries, won := 0, 0
+			for g := 0; g < 100; g++ {
+				r := simGame(BotProfiles[tt.bot1].instance(), BotProfiles[tt.bot2].instance(), 20, 2, int64(g))
+				for p := 1; p <= 2; p++ {
+					if r.Guzumps[p] > r.GuzumpTries[p] {
+						t.Fatalf("game %d: player %d won %d guzumps from %d tries", g, p, r.Guzumps[p], r.GuzumpTries[p])
+					}
+					tries += r.GuzumpTries[p]
+					won += r.Guzumps[p]
+				}
+			}
+			if won == 0 || won >= tries {
+				t.Errorf("%d of %d guzumps won, want some but not all", won, tries)
+			}
+		})
+	}
+}
@@ -2,35 +2,105 @@
 // Server to Client - Message type is first Json field
 //
 //    {Type: "GamesInProgress"
-//     Games: [Array of Games]}
+//     Games: [Array of Games, each with Status, Tmax, Seats, Players, Won]}
+//
+//    {Type: "GameUpdate"   To a client in the lobby, as a game changes
+//     Idx: int
+//     Event: string        Created, Joined, Left, Finished or Freed
+//     Game: {Status, Tmax, Seats, Players, Won, ...}}
 //
 //    {Type: "Flipped"
-//     Tile:  int}
+//     Tile:  int
+//     MyTile: bool
+//     Teammate: bool
+//     Player: int
+//     Display: string}
 //
 //    {Type: "Hidden"
-//     Tile1:  int
+//     Tiles:  "int,int,..."
+//     Tile1:  int    The first and last of Tiles
 //     Tile2:  int}
 //
 //    {Type: "Removed"
-//     Tile1:  int
-//     Tile2:  int}
+//     Tiles:  "int,int,..."
+//     Tile1:  int    The first and last of Tiles
+//     Tile2:  int
+//     Player: int}   Winner of the set, or 0 if nobody
+//
+//    {Type: "BotDecision"
+//     Decision: {Bot, Tile, Action, Reason, Candidates, Remembered}}
+//
+//    {Type: "Turn"      Turn-based games only
+//     Player: int
+//     MyTurn: bool}
+//
+//    {Type: "Error"
+//     Msg: string}
+//
+//    {Type: "Latency"
+//     Rtt: "int,int,..."}  Each player's round-trip time in ms, in seat
+//                          order ("-" for a bot)
+//
+//    {Type: "Invite"       To players of a private game, once seated
+//     Code: string
+//     Tmax: int}
+//
+//    {Type: "Matched"      To a player found a match, once seated
+//     Player: int          The player's seat
+//     Players: [string]    Everyone's name, in seat order
+//     Tmax: int}
+//
+//    {Type: "Watching"     To a spectator, as they start watching and as
+//     Tmax: int             each game starts; the tiles face up, sets won
+//     Players: [string]     and whose turn it is follow as Flipped, Removed
+//     Turns: bool}          and Turn messages
 //
 // Client to Server - Message type in clear text, followed by Json payload
 //
 //    NewGame
 //    {Idx: int
 //     Tmax: int
+//     Seats: int      2 to 6 (default 2)
+//     Teams: int      0 for every player for themselves, or 2 or 3 teams
+//     SetSize: int    Tiles per set, 2 to 4 (default 2); must divide Tmax
+//     TurnBased: bool Players take turns, rather than racing
 //     OppBot: int
+//     Bots: int       Seats filled by OppBot (default all but seat 1)
+//     BotTeam: int    Team whose seats bots fill first (default none)
+//     BotAfter: int   Seconds to wait for players before bots (OppBot, or
+//                     the default bot) fill the free seats (0 for never)
+//     Private: bool   Joined only with the invite code the server sends
+//     Password: string Also needed to join a private game (optional)
 //     Name: string}
 //
 //    JoinGame
 //    {Idx: int
+//     Team: int       Team to join (default any)
+//     Code: string    Invite code of a private game, in place of Idx
+//     Password: string
+//     Name: string}
+//
+//    FindMatch        Play whoever else asks for the same game, or a bot
+//    {Tmax: int
+//     SetSize: int
+//     TurnBased: bool
 //     Name: string}
 //
+//    Spectate
+//    {Idx: int
+//     Code: string    As for JoinGame
+//     Password: string
+//     Explain: bool}
+//
 //    FlipTile
 //    {Tile: int}
 //
 //    End
+//
+// The server also pings the client every PingInterval (a websocket control
+// message, answered by the browser), to measure the connection's round-trip
+// time. A client that sends no pong (or anything else) for PongTimeout has
+// lost its connection: the socket is closed, and a player forfeits.
 // ----------------------------------------------------------------------------
 
 package main
@@ -39,8 +109,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -76,73 +150,186 @@ func startWebsocket(w http.ResponseWriter, r *http.Request) *websocket.Conn {
 }
 
 // ---------------------------------------------------------------------------
-// Blocking function waits until a valid NewGame or JoinGame message is read
+// Blocking function waits until a valid NewGame, JoinGame or Spectate
+// message is read. The player is returned as player 1 for a new game,
+// player 2 for a join (the actual seat is assigned on joining), or player 0
+// for a spectator.
 //
 //    NewGame
 //    {Idx: int
 //     Tmax: int
+//     Seats: int
+//     Teams: int
+//     SetSize: int
+//     TurnBased: bool
 //     OppBot: int
+//     Bots: int
+//     BotTeam: int
 //     Name: string}
 //
 //    JoinGame
 //    {Idx: int
+//     Team: int
 //     Name: string}
+//
+//    Spectate
+//    {Idx: int
+//     Explain: bool}
 // ---------------------------------------------------------------------------
 
-func startOrJoin(conn *websocket.Conn) (player_t, int, int, int, bool) {
+type newGame_t struct {
+	Idx       int
+	Tmax      int
+	Seats     int
+	Teams     int
+	SetSize   int
+	TurnBased bool
+	OppBot    int
+	Bots      int
+	BotTeam   int
+	BotAfter  int // Seconds
+	Private   bool
+	Password  string
+	Team      int    // Team to join, for a JoinGame request
+	Code      string // Invite code, for a JoinGame request
+	Name      string
+
+	match bool // A FindMatch request
+}
+
+func startOrJoin(conn *websocket.Conn) (player_t, newGame_t, bool) {
 	nullPlayer := player_t{}
+	nullGame := newGame_t{}
 
 	for {
 		messageType, msg, err := conn.ReadMessage()
 		if err != nil {
 			log.Println(err)
-			return nullPlayer, 0, 0, 0, false
+			return nullPlayer, nullGame, false
 		}
 
 		if messageType != websocket.TextMessage {
-			return nullPlayer, 0, 0, 0, false
+			return nullPlayer, nullGame, false
 		}
 
 		if VerboseGlobal {
 			log.Println("startOrJoin: received [", string(msg), "]")
 		}
 
-		if string(msg[0:7]) == "NewGame" {
-			type newgame_t struct {
-				Idx    int
-				Tmax   int
-				OppBot int
-				Name   string
-			}
-			var ng newgame_t
+		if strings.HasPrefix(string(msg), "NewGame") {
+			var ng newGame_t
 			log.Println("startOrJoin: unmarshal")
 			json.Unmarshal(msg[7:], &ng)
 
-			if Games[ng.Idx].Status != GAME_EMPTY ||
-				ng.Tmax <= 0 || ng.OppBot < 0 || len(ng.Name) == 0 {
-				return nullPlayer, 0, 0, 0, false
+			if ng.Seats == 0 {
+				ng.Seats = MIN_SEATS
+			}
+			if ng.SetSize == 0 {
+				ng.SetSize = MIN_SET_SIZE
+			}
+			if ng.OppBot > 0 && ng.Bots == 0 {
+				ng.Bots = ng.Seats - 1
+			}
+			if !ng.Private {
+				ng.Password = ""
+			}
+
+			if ng.Idx < 0 || ng.Idx >= len(Games) || Games[ng.Idx].Status != GAME_EMPTY ||
+				ng.SetSize < MIN_SET_SIZE || ng.SetSize > MAX_SET_SIZE ||
+				ng.Tmax <= 0 || ng.Tmax%ng.SetSize != 0 || ng.Tmax > 999 || ng.OppBot < 0 || ng.OppBot >= len(BotProfiles) ||
+				ng.Seats < MIN_SEATS || ng.Seats > MAX_SEATS ||
+				ng.Bots < 0 || ng.Bots >= ng.Seats || (ng.Bots > 0 && ng.OppBot == 0) ||
+				ng.Teams < 0 || ng.Teams == 1 || ng.Teams >= ng.Seats || (ng.Teams > 0 && ng.Seats%ng.Teams != 0) ||
+				ng.BotTeam < 0 || ng.BotTeam > ng.Teams || ng.BotAfter < 0 || ng.BotAfter > MAX_BOT_AFTER ||
+				len(ng.Password) > 64 || len(ng.Name) == 0 {
+				return nullPlayer, nullGame, false
 			}
 
-			player1 := player_t{ng.Name, 1, false, "", 50, 50, nil, nil}
+			player1 := player_t{ng.Name, 1, false, 0, "", nil, false, nil, nil, nil, nil, ""}
 
-			return player1, ng.Tmax, ng.Idx, ng.OppBot, true
+			return player1, ng, true
 		}
 
-		if string(msg[0:8]) == "JoinGame" {
+		if strings.HasPrefix(string(msg), "JoinGame") {
 			type joingame_t struct {
-				Idx  int
-				Name string
+				Idx      int
+				Team     int
+				Code     string
+				Password string
+				Name     string
 			}
 			var jg joingame_t
 			json.Unmarshal(msg[8:], &jg)
+			if jg.Code != "" {
+				jg.Idx = inviteSlot(jg.Code)
+			}
+
+			if jg.Idx < 0 || jg.Idx >= len(Games) || jg.Team < 0 || len(jg.Name) == 0 {
+				return nullPlayer, nullGame, false
+			}
+			GamesMu.Lock()
+			open := Games[jg.Idx].Status == GAME_WAITING && jg.Team <= Games[jg.Idx].Teams
+			tMax := Games[jg.Idx].Tmax
+			GamesMu.Unlock()
+			if !open {
+				return nullPlayer, nullGame, false
+			}
+
+			player2 := player_t{jg.Name, 2, false, 0, "", nil, false, nil, nil, nil, nil, ""}
+
+			return player2, newGame_t{Idx: jg.Idx, Tmax: tMax, Team: jg.Team, Code: jg.Code, Password: jg.Password}, true
+		}
+
+		if strings.HasPrefix(string(msg), "FindMatch") {
+			type findmatch_t struct {
+				Tmax      int
+				SetSize   int
+				TurnBased bool
+				Name      string
+			}
+			var fm findmatch_t
+			json.Unmarshal(msg[9:], &fm)
+
+			if fm.SetSize == 0 {
+				fm.SetSize = MIN_SET_SIZE
+			}
+			if fm.SetSize < MIN_SET_SIZE || fm.SetSize > MAX_SET_SIZE ||
+				fm.Tmax <= 0 || fm.Tmax%fm.SetSize != 0 || fm.Tmax > 999 || len(fm.Name) == 0 {
+				return nullPlayer, nullGame, false
+			}
+
+			player := player_t{fm.Name, 1, false, 0, "", nil, false, nil, nil, nil, nil, ""}
+
+			return player, newGame_t{Tmax: fm.Tmax, SetSize: fm.SetSize, TurnBased: fm.TurnBased, match: true}, true
+		}
+
+		if strings.HasPrefix(string(msg), "Spectate") {
+			type spectate_t struct {
+				Idx      int
+				Code     string
+				Password string
+				Explain  bool
+			}
+			var sp spectate_t
+			json.Unmarshal(msg[8:], &sp)
+			if sp.Code != "" {
+				sp.Idx = inviteSlot(sp.Code)
+			}
 
-			if Games[jg.Idx].Status == GAME_WAITING || len(jg.Name) == 0 {
-				return nullPlayer, 0, 0, 0, false
+			if sp.Idx < 0 || sp.Idx >= len(Games) {
+				return nullPlayer, nullGame, false
+			}
+			GamesMu.Lock()
+			open := Games[sp.Idx].Status == GAME_RUNNING && Games[sp.Idx].admits(sp.Code, sp.Password)
+			tMax := Games[sp.Idx].Tmax
+			GamesMu.Unlock()
+			if !open {
+				return nullPlayer, nullGame, false
 			}
 
-			player2 := player_t{jg.Name, 2, false, "", 0, 0, nil, nil}
+			watcher := player_t{"", 0, false, 0, "", nil, sp.Explain, nil, nil, nil, nil, ""}
 
-			return player2, Games[jg.Idx].Tmax, jg.Idx, 0, true
+			return watcher, newGame_t{Idx: sp.Idx, Tmax: tMax}, true
 		}
 
 		if VerboseGlobal {
@@ -152,21 +339,32 @@ func startOrJoin(conn *websocket.Conn) (player_t, int, int, int, bool) {
 }
 
 // ---------------------------------------------------------------------------
-// Read Flip and End messages from the socket, and put onto the Move channel
+// Read FlipTile and End messages from the socket, and put onto the game's
+// Move channel tagged with the player's number
 // ---------------------------------------------------------------------------
 
-func socketReader(conn *websocket.Conn, move chan string, p int) {
+func socketReader(conn *websocket.Conn, move chan string, p int, link *link_t) {
 	var messageType int
 	var msg []byte
 	var err error
 
+	link.keepAlive(conn)
+
 	// Indefinite loop terminates when client asks to close socket/server or
 	// socket read fails.
 	for {
 		messageType, msg, err = conn.ReadMessage()
 		if err != nil {
-			move <- "N" // close TODO
-			log.Println(err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				log.Println("Player", p, "heartbeat failed")
+			} else {
+				log.Println(err)
+			}
+
+			// The player has gone: forfeit, and stop the writer too
+			conn.Close()
+			move <- fmt.Sprintf("X%d", p)
+			move <- fmt.Sprintf("N%d", p)
 			break
 		}
 
@@ -181,59 +379,200 @@ func socketReader(conn *websocket.Conn, move chan string, p int) {
 			log.Println("Reader: received [", string(msg), "]")
 		}
 
-		var msgMap map[string]interface{}
-
-		json.Unmarshal(msg, &msgMap)
-
-		if msgMap["Type"] == "Flip" {
-			move <- fmt.Sprintf("F%1d%03d", p, msgMap["Tile"])
-		} else if msgMap["Type"] == "End" {
-			move <- "E"
+		if strings.HasPrefix(string(msg), "FlipTile") {
+			type fliptile_t struct {
+				Tile int
+			}
+			var ft fliptile_t
+			json.Unmarshal(msg[8:], &ft)
+			move <- fmt.Sprintf("F%1d%03d/%d", p, ft.Tile, link.latency().Microseconds())
+		} else if strings.HasPrefix(string(msg), "End") {
+			move <- fmt.Sprintf("E%1d", p)
 		}
 	} // For loop
-	SessWG.Done()
 }
 
 // ---------------------------------------------------------------------------
-// Read board channel and tell client which tiles to flip/hide/remove
+// Pass on the moves of a player read before they had a seat, retagged with
+// seat p, until the reader stops (gone closes). With no move channel, the
+// moves are dropped.
 // ---------------------------------------------------------------------------
 
-func socketWriter(conn *websocket.Conn, board chan string, p int) {
-	var sent bool
+func relayMoves(relay chan string, gone chan bool, move chan string, p int) {
+	pass := func(msg string) {
+		if move != nil {
+			move <- msg[:1] + strconv.Itoa(p) + msg[2:]
+		}
+	}
 	for {
 		select {
-		case b := <-board:
-			if b[0] == 'F' || b[0] == 'O' { // (F)lipped
-				idx, _ := strconv.Atoi(b[1:4])
-				revealed_val, _ := strconv.Atoi(b[4:7])
-				myTile := true
-				if b[0] == 'O' {
-					myTile = false
-				}
-				sent = SendFlipTiles(conn, myTile, idx, revealed_val)
-			} else if b[0] == 'H' { // (H)ide unmatched tiles
-				idx1, _ := strconv.Atoi(b[1:4])
-				idx2, _ := strconv.Atoi(b[4:7])
-				sent = SendHideTiles(conn, idx1, idx2)
-			} else if b[0] == 'R' { // (R)emove matched tiles
-				idx1, _ := strconv.Atoi(b[1:4])
-				idx2, _ := strconv.Atoi(b[4:7])
-				sent = SendRemoveTiles(conn, idx1, idx2)
+		case msg := <-relay:
+			pass(msg)
+		case <-gone:
+			// The reader's last moves were relayed before it stopped
+			for len(relay) > 0 {
+				pass(<-relay)
 			}
+			return
 		}
-		if !sent {
-			break
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Drain the connection's outbound queue and tell client which tiles to
+// flip/hide/remove, or in the lobby, how the games in progress change. A
+// socket has one writer at a time: the queue's done channel closes when this
+// one stops.
+// ---------------------------------------------------------------------------
+
+func socketWriter(conn *websocket.Conn, queue *outQueue_t, p int) {
+	ping := time.NewTicker(PingInterval)
+	defer ping.Stop()
+
+	sent := true
+	for sent {
+		select {
+		case <-ping.C:
+			sent = sendPing(conn)
+		case <-queue.ready:
+			msgs, open := queue.take()
+			sent = open
+			for _, b := range msgs {
+				if !sent {
+					break
+				}
+				sent = sendBoardMsg(conn, b, p)
+			}
 		}
 	}
-	SessWG.Done()
+	close(queue.done)
+}
+
+func sendBoardMsg(conn *websocket.Conn, b string, p int) bool {
+	if b[0] == 'F' || b[0] == 'O' || b[0] == 'T' { // (F)lipped
+		idx, _ := strconv.Atoi(b[1:4])
+		revealed_val, _ := strconv.Atoi(b[4:7])
+		flipper, _ := strconv.Atoi(b[7:8])
+		return SendFlipTiles(conn, b[0] == 'F', b[0] == 'T', flipper, idx, revealed_val)
+	} else if b[0] == 'H' { // (H)ide unmatched tiles
+		tiles, _ := msgTiles(b)
+		return SendHideTiles(conn, tiles)
+	} else if b[0] == 'R' { // (R)emove matched tiles
+		tiles, winner := msgTiles(b)
+		return SendRemoveTiles(conn, tiles, winner)
+	} else if b[0] == 'D' { // Bot (D)ecision
+		return SendBotDecision(conn, b[1:])
+	} else if b[0] == 'P' { // (P)layer's turn
+		player, _ := strconv.Atoi(b[1:2])
+		return SendTurn(conn, player, player == p)
+	} else if b[0] == 'E' { // (E)rror
+		return SendError(conn, b[1:])
+	} else if b[0] == 'L' { // (L)atencies
+		return SendLatency(conn, b[1:])
+	} else if b[0] == '{' { // Lobby message, already Json
+		return writeMsg(conn, []byte(b))
+	}
+	return true
+}
+
+// ---------------------------------------------------------------------------
+// Heartbeat and round-trip time of a client's connection. The socket writer
+// pings the client with the time sent, and the socket reader times the pong
+// that echoes it back. The game manager takes half the round trip as the
+// client's latency. Each pong also puts off the reader's deadline, so a
+// connection that stops answering fails its next read.
+// ---------------------------------------------------------------------------
+
+var PingInterval = 2 * time.Second
+var PongTimeout = 10 * time.Second
+
+type link_t struct {
+	mu  sync.Mutex
+	rtt time.Duration // Smoothed round-trip time, 0 until measured
 }
 
-func SendFlipTiles(conn *websocket.Conn, myTile bool, idx, val int) bool {
+func (link *link_t) keepAlive(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(PongTimeout))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(PongTimeout))
+		return link.pong(appData)
+	})
+}
+
+func sendPing(conn *websocket.Conn) bool {
+	now := time.Now()
+	err := conn.WriteControl(websocket.PingMessage, []byte(strconv.FormatInt(now.UnixNano(), 10)), now.Add(PingInterval))
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (link *link_t) pong(appData string) error {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return nil
+	}
+	rtt := time.Since(time.Unix(0, sent))
+
+	// Smooth as TCP does, so one slow pong does not swing the estimate
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.rtt == 0 {
+		link.rtt = rtt
+	} else {
+		link.rtt = (7*link.rtt + rtt) / 8
+	}
+	return nil
+}
+
+func (link *link_t) roundTrip() time.Duration {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.rtt
+}
+
+func (link *link_t) latency() time.Duration {
+	return link.roundTrip() / 2
+}
+
+func SendTurn(conn *websocket.Conn, player int, myTurn bool) bool {
+	msgMap := map[string]string{
+		"Type":   "Turn",
+		"Player": fmt.Sprint(player),
+		"MyTurn": strconv.FormatBool(myTurn),
+	}
+
+	return sendJsonMsg(conn, &msgMap)
+}
+
+func SendLatency(conn *websocket.Conn, rtts string) bool {
+	msgMap := map[string]string{
+		"Type": "Latency",
+		"Rtt":  rtts,
+	}
+
+	return sendJsonMsg(conn, &msgMap)
+}
+
+func SendError(conn *websocket.Conn, errMsg string) bool {
+	msgMap := map[string]string{
+		"Type": "Error",
+		"Msg":  errMsg,
+	}
+
+	return sendJsonMsg(conn, &msgMap)
+}
+
+func SendFlipTiles(conn *websocket.Conn, myTile, teammate bool, flipper, idx, val int) bool {
 	msgMap := map[string]string{
-		"Type":    "Flipped",
-		"Tile":    fmt.Sprint(idx),
-		"MyTile":  strconv.FormatBool(myTile),
-		"Display": TileFaces[val],
+		"Type":     "Flipped",
+		"Tile":     fmt.Sprint(idx),
+		"MyTile":   strconv.FormatBool(myTile),
+		"Teammate": strconv.FormatBool(teammate),
+		"Player":   fmt.Sprint(flipper),
+		"Display":  TileFaces[val],
 	}
 
 	return sendJsonMsg(conn, &msgMap)
@@ -248,7 +587,17 @@ func sendJsonMsg(conn *websocket.Conn, msgMap *map[string]string) bool {
 		fmt.Println("Json Message to client:", string(msgJson))
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, msgJson)
+	return writeMsg(conn, msgJson)
+}
+
+// ---------------------------------------------------------------------------
+// Write a message to the client. A client that cannot take it within the
+// pong timeout has gone.
+// ---------------------------------------------------------------------------
+
+func writeMsg(conn *websocket.Conn, msg []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(PongTimeout))
+	err := conn.WriteMessage(websocket.TextMessage, msg)
 	if err != nil {
 		log.Println(err)
 		return false
@@ -256,11 +605,12 @@ func sendJsonMsg(conn *websocket.Conn, msgMap *map[string]string) bool {
 	return true
 }
 
-func SendHideTiles(conn *websocket.Conn, tile1, tile2 int) bool {
+func SendHideTiles(conn *websocket.Conn, tiles []int) bool {
 	msgMap := map[string]string{
 		"Type":  "Hidden",
-		"Tile1": fmt.Sprint(tile1),
-		"Tile2": fmt.Sprint(tile2),
+		"Tiles": joinTiles(tiles),
+		"Tile1": fmt.Sprint(tiles[0]),
+		"Tile2": fmt.Sprint(tiles[len(tiles)-1]),
 	}
 	msgJson, err := json.Marshal(msgMap)
 	if err != nil {
@@ -270,19 +620,16 @@ func SendHideTiles(conn *websocket.Conn, tile1, tile2 int) bool {
 		fmt.Println("Json Message to client:", string(msgJson))
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, msgJson)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	return true
+	return writeMsg(conn, msgJson)
 }
 
-func SendRemoveTiles(conn *websocket.Conn, tile1, tile2 int) bool {
+func SendRemoveTiles(conn *websocket.Conn, tiles []int, winner int) bool {
 	msgMap := map[string]string{
-		"Type":  "Removed",
-		"Tile1": fmt.Sprint(tile1),
-		"Tile2": fmt.Sprint(tile2),
+		"Type":   "Removed",
+		"Tiles":  joinTiles(tiles),
+		"Tile1":  fmt.Sprint(tiles[0]),
+		"Tile2":  fmt.Sprint(tiles[len(tiles)-1]),
+		"Player": fmt.Sprint(winner),
 	}
 	msgJson, err := json.Marshal(msgMap)
 	if err != nil {
@@ -292,21 +639,42 @@ func SendRemoveTiles(conn *websocket.Conn, tile1, tile2 int) bool {
 		fmt.Println("Json Message to client:", string(msgJson))
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, msgJson)
-	if err != nil {
-		log.Println(err)
-		return false
+	return writeMsg(conn, msgJson)
+}
+
+func joinTiles(tiles []int) string {
+	strs := make([]string, len(tiles))
+	for i, idx := range tiles {
+		strs[i] = strconv.Itoa(idx)
 	}
-	return true
+	return strings.Join(strs, ",")
 }
 
 // ---------------------------------------------------------------------------
-// Send client a list of games in progress, including an empty "New" game
+// Send client a bot's explanation of a decision (already in Json)
 // ---------------------------------------------------------------------------
 
-func SendGamesInProgress(conn *websocket.Conn) bool {
+func SendBotDecision(conn *websocket.Conn, decJson string) bool {
+	fullJson := fmt.Sprintf("{\"Type\":\"BotDecision\",\"Decision\":%s}", decJson)
+	if VerboseGlobal {
+		fmt.Println("Json Message to client:", fullJson)
+	}
+
+	return writeMsg(conn, []byte(fullJson))
+}
 
-	msgJson, err := json.Marshal(Games)
+// ---------------------------------------------------------------------------
+// The list of games in progress, including an empty "New" game, for a
+// client in the lobby. Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func gamesInProgressMsg() string {
+
+	games := make([]*game_t, len(Games))
+	for g := range Games {
+		games[g] = Games[g].lobbyView()
+	}
+	msgJson, err := json.Marshal(games)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -315,11 +683,65 @@ func SendGamesInProgress(conn *websocket.Conn) bool {
 	}
 
 	// Manually prepend type
-	fullJson := fmt.Sprintf("{\"Type\":\"GamesInProgress\",\"Games\":%s}", string(msgJson))
-	err = conn.WriteMessage(websocket.TextMessage, []byte(fullJson))
+	return fmt.Sprintf("{\"Type\":\"GamesInProgress\",\"Games\":%s}", string(msgJson))
+}
+
+// ---------------------------------------------------------------------------
+// A change to one game, for a client in the lobby. Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func gameUpdateMsg(game *game_t, event string) string {
+	type gameUpdate_t struct {
+		Type  string
+		Idx   int
+		Event string
+		Game  *game_t
+	}
+
+	msgJson, err := json.Marshal(gameUpdate_t{"GameUpdate", game.idx, event, game.lobbyView()})
 	if err != nil {
-		log.Println(err)
-		return false
+		log.Fatalln(err)
 	}
-	return true
+	return string(msgJson)
+}
+
+// ---------------------------------------------------------------------------
+// A private game's invite code, for its players
+// ---------------------------------------------------------------------------
+
+func inviteMsg(game *game_t) string {
+	type invite_t struct {
+		Type string
+		Code string
+		Tmax int
+	}
+
+	msgJson, err := json.Marshal(invite_t{"Invite", game.invite, game.Tmax})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return string(msgJson)
+}
+
+// ---------------------------------------------------------------------------
+// Who a player found a match is playing
+// ---------------------------------------------------------------------------
+
+func matchedMsg(game *game_t, p int) string {
+	type matched_t struct {
+		Type    string
+		Player  int
+		Players []string
+		Tmax    int
+	}
+
+	names := []string{}
+	for _, player := range game.Players {
+		names = append(names, player.Name)
+	}
+	msgJson, err := json.Marshal(matched_t{"Matched", p, names, game.Tmax})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return string(msgJson)
 }
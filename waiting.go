@@ -0,0 +1,130 @@
+// ---------------------------------------------------------------------------
+// Games waiting for players.
+//
+// A game waits until every seat is filled. Its creator may ask for bots to
+// fill the free seats if nobody has joined within a given time; the game then
+// starts, run by the goroutine that waited. A game still not full after
+// WaitTimeout is started the same way, with bots (the creator's choice, or
+// the default) in the free seats, if anybody has joined the creator. One
+// nobody has joined is abandoned: its slot is freed, and its creator is
+// disconnected. So is the slot of a game everybody leaves before it starts.
+// A player who leaves a waiting game gives up their seat to whoever comes
+// next.
+// ---------------------------------------------------------------------------
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const MAX_BOT_AFTER = 3600 // Seconds
+
+// How long a game may wait for players before it is abandoned
+var WaitTimeout = 10 * time.Minute
+
+// ---------------------------------------------------------------------------
+// Wait for players to fill the game, filling it with bots after botAfter
+// (if not 0), or after WaitTimeout if anybody has joined, and otherwise
+// abandoning it then. Runs as its own goroutine from when the game is
+// created until it stops waiting.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) awaitPlayers(botAfter time.Duration, oppBot int) {
+	waiting := game.waiting // This game, not any later one in its slot
+
+	var fillIn <-chan time.Time
+	if botAfter > 0 {
+		fillIn = time.After(botAfter)
+	}
+	abandon := time.After(WaitTimeout)
+
+	botsAsked := false
+	select {
+	case <-waiting:
+		return
+	case <-fillIn:
+		botsAsked = true
+	case <-abandon:
+	}
+
+	GamesMu.Lock()
+	if game.waiting != waiting || game.Status != GAME_WAITING {
+		GamesMu.Unlock()
+		return
+	}
+	if !botsAsked && !game.joined() {
+		log.Println("Nobody joined game", game.idx, "in time: abandoned")
+		for _, player := range game.Players {
+			if player.queue != nil {
+				player.queue.hangUp()
+			}
+		}
+		game.free()
+		GamesMu.Unlock()
+		return
+	}
+
+	log.Println("Game", game.idx, "not filled in time: bots fill the free seats")
+	game.seatBots(game.Seats, oppBot, 0)
+	game.lobbyUpdate(LOBBY_JOINED)
+	GamesMu.Unlock()
+
+	gameManager(game, VerboseGlobal)
+}
+
+// ---------------------------------------------------------------------------
+// Returns: true if a human other than the creator (in seat 1) has taken a
+// seat. Called with GamesMu held.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) joined() bool {
+	for _, player := range game.Players[1:] {
+		if player.Num != 0 && !player.IsBot {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// A human whose connection is given by its queue has left. If the game is
+// still waiting for players, the seat is freed, and so is the game's slot if
+// no human is left in it.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) unseat(p int, queue *outQueue_t) {
+	GamesMu.Lock()
+	defer GamesMu.Unlock()
+
+	if game.Status != GAME_WAITING || p > len(game.Players) || game.Players[p-1].queue != queue {
+		return
+	}
+	game.Players[p-1] = player_t{}
+	game.dropMoves(p)
+
+	for _, player := range game.Players {
+		if player.Num != 0 && !player.IsBot {
+			game.lobbyUpdate(LOBBY_LEFT)
+			return
+		}
+	}
+	game.free()
+}
+
+// ---------------------------------------------------------------------------
+// Forget the moves a player sent before leaving a game that has not started,
+// so that nobody who takes the seat later forfeits for them. Called with
+// GamesMu held, when no game manager is reading the moves.
+// ---------------------------------------------------------------------------
+
+func (game *game_t) dropMoves(p int) {
+	tag := byte('0' + p)
+	for n := len(game.moves); n > 0; n-- {
+		msg := <-game.moves
+		if msg[1] != tag {
+			game.moves <- msg
+		}
+	}
+}
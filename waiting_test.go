@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Whether anybody has joined a game's creator
+// ---------------------------------------------------------------------------
+
+func TestJoined(t *testing.T) {
+	human := func(p int) player_t { return player_t{Name: "h", Num: p} }
+	bot := func(p int) player_t { return player_t{Name: "b", Num: p, IsBot: true} }
+	tests := []struct {
+		name    string
+		players []player_t
+		joined  bool
+	}{
+		{"creator alone", []player_t{human(1), {}, {}}, false},
+		{"creator and a bot", []player_t{human(1), {}, bot(3)}, false},
+		{"somebody joined", []player_t{human(1), {}, human(3)}, true},
+		{"creator left", []player_t{{}, human(2), {}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game := &game_t{Players: tt.players}
+			if joined := game.joined(); joined != tt.joined {
+				t.Errorf("joined %v, want %v", joined, tt.joined)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// A game nobody joins is abandoned when the wait runs out, and its creator
+// disconnected
+// ---------------------------------------------------------------------------
+
+func TestAbandonNobodyJoined(t *testing.T) {
+	VerboseGlobal = false
+	defer func(wait time.Duration) { WaitTimeout = wait }(WaitTimeout)
+	WaitTimeout = 10 * time.Millisecond
+
+	hungUp := make(chan bool)
+	creator := player_t{Name: "creator", Num: 1, queue: newOutQueue(func() { close(hungUp) })}
+	game, full, ok := newGame(newGame_t{Idx: len(Games) - 1, Tmax: 12, Seats: 3, SetSize: 2}, creator)
+	if !ok || full {
+		t.Fatalf("new game: ok %v, full %v", ok, full)
+	}
+
+	select {
+	case <-hungUp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creator not disconnected")
+	}
+	GamesMu.Lock()
+	status := game.Status
+	GamesMu.Unlock()
+	if status != GAME_EMPTY {
+		t.Errorf("status %d, want the slot freed", status)
+	}
+}